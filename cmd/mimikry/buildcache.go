@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nikoksr/mimikry/pkg/docker"
+	"github.com/nikoksr/simplog"
+)
+
+type (
+	// buildRecords holds the inputs and the resulting digest of the last pushed build of each version of a target
+	// repository. It lets a run skip versions whose inputs haven't changed since.
+	buildRecords struct {
+		TargetRepo string                 `json:"target_repo"`
+		Modified   time.Time              `json:"modified"`
+		Builds     map[string]buildRecord `json:"builds"`
+
+		path string
+	}
+
+	// buildRecord is the last pushed build of a version.
+	buildRecord struct {
+		Inputs string `json:"inputs"` // Hash over everything that goes into the build
+		Digest string `json:"digest"` // Manifest digest of the pushed version tag
+	}
+
+	// buildInputs is everything that goes into the build of a version.
+	buildInputs struct {
+		SourceDigest     string
+		Files            map[string][]byte // The build context, keyed by slash-separated path
+		Options          docker.BuildOptions
+		Tags             []string
+		PostBuildSnippet []byte
+	}
+)
+
+func buildRecordsPath(targetRepo string) string {
+	key := sha256.Sum256([]byte(targetRepo))
+
+	return filepath.FromSlash(filepath.Join(buildRecordDirectory, hex.EncodeToString(key[:8])+".json"))
+}
+
+// loadBuildRecords loads the build records of the given target repository. If none exist yet, empty ones are returned.
+func loadBuildRecords(targetRepo string) (*buildRecords, error) {
+	records := &buildRecords{
+		TargetRepo: targetRepo,
+		Builds:     make(map[string]buildRecord),
+		path:       buildRecordsPath(targetRepo),
+	}
+
+	content, err := os.ReadFile(records.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return records, nil
+		}
+
+		return nil, fmt.Errorf("read build records: %w", err)
+	}
+
+	if err = json.Unmarshal(content, records); err != nil {
+		return nil, fmt.Errorf("decode build records: %w", err)
+	}
+
+	// Guard against hash collisions of the file name
+	if records.TargetRepo != targetRepo || records.Builds == nil {
+		records.Builds = make(map[string]buildRecord)
+	}
+
+	return records, nil
+}
+
+// record stores the inputs and resulting digest of a pushed build and persists the records.
+func (r *buildRecords) record(version, inputs, digest string) error {
+	r.Builds[version] = buildRecord{Inputs: inputs, Digest: digest}
+	r.Modified = time.Now()
+
+	content, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("encode build records: %w", err)
+	}
+
+	return writeFileAtomic(r.path, content, 0o600)
+}
+
+// upToDate reports whether the last pushed build of the given version had the given inputs and the given tag still
+// points at its result in the registry.
+func (r *buildRecords) upToDate(ctx context.Context, images docker.ImageClient, version, inputs, tag string) bool {
+	record, ok := r.Builds[version]
+	if !ok || record.Inputs != inputs || record.Digest == "" {
+		return false
+	}
+
+	digest, err := images.RemoteDigest(ctx, tag)
+	if err != nil {
+		simplog.FromContext(ctx).Debugf("Failed to resolve digest of %s: %v", tag, err)
+		return false
+	}
+
+	return digest == record.Digest
+}
+
+// readContextFiles reads all files of the given build directory, keyed by their slash-separated path.
+func readContextFiles(dir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(name)] = content
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read build directory: %w", err)
+	}
+
+	return files, nil
+}
+
+// hash returns a hash over all inputs. Build resources and other options that don't affect the built image are left
+// out.
+func (in buildInputs) hash() string {
+	hash := sha256.New()
+
+	writeField := func(name string, value []byte) {
+		_, _ = fmt.Fprintf(hash, "%s\x00%d\x00", name, len(value))
+		_, _ = hash.Write(value)
+	}
+
+	writeMap := func(prefix string, values map[string]string) {
+		for _, key := range sortedKeys(values) {
+			writeField(prefix+key, []byte(values[key]))
+		}
+	}
+
+	writeField("source", []byte(in.SourceDigest))
+	for _, name := range sortedKeys(in.Files) {
+		writeField("file:"+name, in.Files[name])
+	}
+
+	writeMap("arg:", in.Options.BuildArgs)
+	writeMap("label:", in.Options.Labels)
+	writeField("platform", []byte(in.Options.Platform))
+	if !in.Options.SourceDateEpoch.IsZero() {
+		writeField("epoch", []byte(strconv.FormatInt(in.Options.SourceDateEpoch.Unix(), 10)))
+	}
+
+	for _, tag := range in.Tags {
+		writeField("tag", []byte(tag))
+	}
+	writeField("post-build", in.PostBuildSnippet)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// sortedKeys returns the keys of the given map in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// resolveHash completes the inputs with the digest of the given source image, as known to its registry, and, unless
+// the build context is set already, the files of the given build directory. It returns the hash over the completed
+// inputs. Resolving the source digest makes a rebuilt source image count as changed input.
+func (in buildInputs) resolveHash(ctx context.Context, images docker.ImageClient, sourceRef, buildDir string) (string, error) {
+	var err error
+	if in.SourceDigest, err = images.RemoteDigest(ctx, sourceRef); err != nil {
+		return "", fmt.Errorf("resolve digest of %s: %w", sourceRef, err)
+	}
+
+	if in.Files == nil {
+		if in.Files, err = readContextFiles(buildDir); err != nil {
+			return "", err
+		}
+	}
+
+	return in.hash(), nil
+}
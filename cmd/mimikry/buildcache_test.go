@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// parseTestTemplates writes the given files into a template directory and parses it like a run would.
+func parseTestTemplates(t *testing.T, files map[string]string) *template.Template {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	templates, err := parseTemplates(dir)
+	if err != nil {
+		t.Fatalf("parseTemplates() error = %v", err)
+	}
+
+	return templates
+}
+
+func TestReadContextFilesMatchesRenderContextFiles(t *testing.T) {
+	t.Parallel()
+
+	templates := parseTestTemplates(t, map[string]string{
+		"Dockerfile":          "FROM postgres:{{ .Version }}\n{{ template \"partials/labels\" . }}\nCOPY config.env /etc/\n",
+		"config.env":          "PG_VERSION={{ .Version }}\nMAINTAINER={{ .Maintainer }}\n",
+		"_helpers":            "{{ define \"unused\" }}{{ end }}",
+		"partials/labels":     "LABEL maintainer={{ .Maintainer }}",
+		"entrypoint.sh":       "#!/bin/sh\nexec postgres \"$@\"\n",
+		"config/settings.yml": "ignored: true\n",
+	})
+
+	tests := []struct {
+		name string
+		opts *options
+	}{
+		{name: "defaults", opts: &options{Maintainer: "John Doe"}},
+		{name: "default dockerignore", opts: &options{Maintainer: "John Doe", DefaultIgnore: true}},
+		{name: "source date", opts: &options{Maintainer: "John Doe", SourceDate: time.Unix(1700000000, 0)}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			version := semver.MustParse("16.3")
+
+			want, err := renderContextFiles(version, templates, tt.opts)
+			if err != nil {
+				t.Fatalf("renderContextFiles() error = %v", err)
+			}
+
+			dir := t.TempDir()
+			if err = prepareBuildDirectory(dir, version, templates, tt.opts); err != nil {
+				t.Fatalf("prepareBuildDirectory() error = %v", err)
+			}
+
+			got, err := readContextFiles(dir)
+			if err != nil {
+				t.Fatalf("readContextFiles() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("readContextFiles() = %q, want %q", got, want)
+			}
+		})
+	}
+}
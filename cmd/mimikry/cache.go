@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/nikoksr/simplog"
+	"github.com/spf13/pflag"
+
+	"github.com/nikoksr/mimikry/pkg/docker"
+)
+
+func printCacheHelp(flags *pflag.FlagSet) func() {
+	return func() {
+		_, _ = fmt.Fprint(os.Stderr, `Usage:
+
+  mimikry cache warm [OPTIONS] SOURCE-REPO...
+
+Fetches the tags of each source repository and stores them in the tag cache, without building anything. This allows
+running the tag discovery in its own, cached CI stage.
+
+Options:
+
+`)
+
+		flags.PrintDefaults()
+	}
+}
+
+// runCacheCommand runs the cache subcommand with the given arguments, i.e. everything after "mimikry cache".
+func runCacheCommand(ctx context.Context, args []string) error {
+	flags := pflag.NewFlagSet("cache", pflag.ContinueOnError)
+	debug := flags.Bool("debug", false, "Enable debug mode")
+	userAgent := flags.String("user-agent", defaultUserAgent(), "The User-Agent header sent with registry API requests")
+	registryRPS := flags.Float64("registry-rps", docker.DefaultRegistryRPS, "Maximum number of registry API requests per second; 0 disables the limit")
+	maxTagPages := flags.Int("max-tag-pages", docker.DefaultMaxTagPages, "Maximum number of registry API pages fetched per repository; 0 disables the limit")
+	proxy := flags.String("proxy", "", "Proxy URL for registry API requests; overrides HTTP_PROXY, HTTPS_PROXY and NO_PROXY")
+	flags.Usage = printCacheHelp(flags)
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+
+		return err
+	}
+
+	if flags.NArg() < 2 || flags.Arg(0) != "warm" {
+		flags.Usage()
+		return errors.New("missing arguments; expected: mimikry cache warm SOURCE-REPO...")
+	}
+
+	logger := simplog.NewClientLogger(*debug)
+	ctx = simplog.WithLogger(ctx, logger)
+
+	docker.SetUserAgent(*userAgent)
+	docker.SetRateLimit(*registryRPS)
+	docker.SetMaxTagPages(*maxTagPages)
+	if *proxy != "" {
+		if err := docker.SetProxy(*proxy); err != nil {
+			return err
+		}
+	}
+
+	for _, repo := range flags.Args()[1:] {
+		if err := warmTagCache(ctx, []string{repo}); err != nil {
+			return fmt.Errorf("warm tag cache of %s: %w", repo, err)
+		}
+	}
+
+	return nil
+}
+
+// warmTagCache fetches the tags of the given source repositories and replaces their tag cache.
+func warmTagCache(ctx context.Context, repos []string) error {
+	logger := simplog.FromContext(ctx)
+	path := tagCachePath(repos)
+
+	unlock, err := lockTagCache(ctx, path)
+	if err != nil {
+		return fmt.Errorf("lock tag cache: %w", err)
+	}
+	defer unlock()
+
+	logger.Infof("Loading tags of %s", tagCacheImage(repos))
+	cache, err := fetchRepoTags(ctx, repos, nil, nil)
+	if err != nil {
+		return fmt.Errorf("load remote tags: %w", err)
+	}
+
+	if err = saveTagCache(path, cache); err != nil {
+		return err
+	}
+
+	logger.Infof("Cached %d tags in %s", len(cache.Tags), path)
+
+	return nil
+}
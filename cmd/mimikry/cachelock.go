@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// tagCacheLockRetryInterval is the time between attempts to acquire a held tag cache lock.
+	tagCacheLockRetryInterval = 100 * time.Millisecond
+	// tagCacheLockStaleAfter is the age after which a lock is considered abandoned, e.g. by a killed process, and
+	// gets broken. Locks are held through fetching the remote tags, which can take longer than that, so holders keep
+	// refreshing the lock's modification time; only locks of processes that are gone get this old.
+	tagCacheLockStaleAfter = 2 * time.Minute
+	// tagCacheLockHeartbeatInterval is the time between refreshes of a held lock's modification time.
+	tagCacheLockHeartbeatInterval = tagCacheLockStaleAfter / 4
+)
+
+// staleLockCount makes the names stale locks are moved to unique within the process.
+var staleLockCount atomic.Uint64
+
+// lockTagCache acquires the lock of the tag cache file at the given path and returns a function that releases it. Each
+// cache file has its own lock, so jobs working on different source repositories never wait for each other, even if
+// they share the cache directory. It blocks until the lock is acquired or the context is done.
+func lockTagCache(ctx context.Context, path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("create tag cache directory: %w", err)
+	}
+
+	lockPath := path + ".lock"
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_, _ = file.WriteString(strconv.Itoa(os.Getpid()))
+			_ = file.Close()
+
+			return holdTagCacheLock(lockPath), nil
+		}
+
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("create tag cache lock: %w", err)
+		}
+
+		// Break locks left behind by processes that didn't get to release them
+		if breakStaleLock(lockPath) {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("wait for tag cache lock: %w", ctx.Err())
+		case <-time.After(tagCacheLockRetryInterval):
+		}
+	}
+}
+
+// holdTagCacheLock refreshes the modification time of the acquired lock at the given path until the returned function
+// is called, which releases the lock.
+func holdTagCacheLock(lockPath string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(tagCacheLockHeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				now := time.Now()
+				_ = os.Chtimes(lockPath, now, now)
+			}
+		}
+	}()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+			_ = os.Remove(lockPath)
+		})
+	}
+}
+
+// breakStaleLock removes the lock at the given path if it's stale and reports whether it did. The lock is first moved
+// to a unique name, so of several processes finding the same stale lock, only one breaks it. If the lock got replaced
+// by a live one in the meantime, that one is put back.
+func breakStaleLock(lockPath string) bool {
+	if info, err := os.Stat(lockPath); err != nil || time.Since(info.ModTime()) <= tagCacheLockStaleAfter {
+		return false
+	}
+
+	stalePath := fmt.Sprintf("%s.stale.%d.%d", lockPath, os.Getpid(), staleLockCount.Add(1))
+	if err := os.Rename(lockPath, stalePath); err != nil {
+		return false
+	}
+	defer func() { _ = os.Remove(stalePath) }()
+
+	if info, err := os.Stat(stalePath); err == nil && time.Since(info.ModTime()) <= tagCacheLockStaleAfter {
+		// Linking fails if yet another lock got acquired meanwhile; its holder is on its own then
+		_ = os.Link(stalePath, lockPath)
+
+		return false
+	}
+
+	return true
+}
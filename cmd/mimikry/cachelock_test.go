@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLockTagCacheExclusive(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "tags.json")
+
+	const workers, rounds = 16, 10
+
+	var holders atomic.Int32
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*rounds)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < rounds; j++ {
+				unlock, err := lockTagCache(context.Background(), path)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				if n := holders.Add(1); n != 1 {
+					errs <- errors.New("lock held by more than one holder")
+				}
+				time.Sleep(time.Millisecond)
+				holders.Add(-1)
+
+				unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path + ".lock"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("lock file left behind: %v", err)
+	}
+}
+
+func TestLockTagCacheBreaksStaleLock(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "tags.json")
+	lockPath := path + ".lock"
+	if err := os.WriteFile(lockPath, []byte("1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	abandoned := time.Now().Add(-2 * tagCacheLockStaleAfter)
+	if err := os.Chtimes(lockPath, abandoned, abandoned); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	unlock, err := lockTagCache(ctx, path)
+	if err != nil {
+		t.Fatalf("lockTagCache() error = %v", err)
+	}
+	unlock()
+
+	leftovers, err := filepath.Glob(lockPath + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftovers) != 0 {
+		t.Fatalf("lock files left behind: %v", leftovers)
+	}
+}
+
+func TestLockTagCacheKeepsLiveLock(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "tags.json")
+	unlock, err := lockTagCache(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*tagCacheLockRetryInterval)
+	defer cancel()
+
+	if _, err = lockTagCache(ctx, path); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("lockTagCache() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+type (
+	// runSummary is the persisted outcome of all previous runs for a target repository. It's the baseline the changelog
+	// of the next run is computed against.
+	runSummary struct {
+		TargetRepo string    `json:"target_repo"`
+		Modified   time.Time `json:"modified"`
+		Versions   []string  `json:"versions"`
+		Latest     string    `json:"latest"`
+
+		path string
+	}
+
+	// changelog lists what a run changed compared to the previous runs.
+	changelog struct {
+		Added   []string
+		Updated []string
+		Latest  string // The version that newly received the latest tag; empty if the latest tag didn't move
+	}
+)
+
+func runSummaryPath(targetRepo string) string {
+	key := sha256.Sum256([]byte(targetRepo))
+
+	return filepath.FromSlash(filepath.Join(runSummaryDirectory, hex.EncodeToString(key[:8])+".json"))
+}
+
+// loadRunSummary loads the summary of the previous runs for the given target repository. If no summary exists yet, an
+// empty one is returned.
+func loadRunSummary(targetRepo string) (*runSummary, error) {
+	summary := &runSummary{
+		TargetRepo: targetRepo,
+		path:       runSummaryPath(targetRepo),
+	}
+
+	content, err := os.ReadFile(summary.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return summary, nil
+		}
+
+		return nil, fmt.Errorf("read run summary: %w", err)
+	}
+
+	if err = json.Unmarshal(content, summary); err != nil {
+		return nil, fmt.Errorf("decode run summary: %w", err)
+	}
+
+	// Guard against hash collisions of the file name
+	if summary.TargetRepo != targetRepo {
+		summary.Versions = nil
+		summary.Latest = ""
+	}
+
+	return summary, nil
+}
+
+// save persists the summary.
+func (s *runSummary) save() error {
+	s.Modified = time.Now()
+
+	content, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("encode run summary: %w", err)
+	}
+
+	return writeFileAtomic(s.path, content, 0o600)
+}
+
+// update computes the changelog of the given results against the summary and records the pushed versions in it. The
+// latest version is only considered if it has been pushed.
+func (s *runSummary) update(results []*versionResult, latestVersion string) changelog {
+	var log changelog
+	for _, result := range results {
+		if result.Status != statusPushed {
+			continue
+		}
+
+		if slices.Contains(s.Versions, result.Version) {
+			log.Updated = append(log.Updated, result.Version)
+			continue
+		}
+
+		log.Added = append(log.Added, result.Version)
+		s.Versions = append(s.Versions, result.Version)
+	}
+
+	pushedLatest := slices.Contains(log.Added, latestVersion) || slices.Contains(log.Updated, latestVersion)
+	if latestVersion != "" && pushedLatest && latestVersion != s.Latest {
+		log.Latest = latestVersion
+		s.Latest = latestVersion
+	}
+
+	return log
+}
+
+// markdown renders the changelog as a markdown snippet, ready to be used in a pull request or chat message.
+func (c changelog) markdown(sourceRepo, targetRepo, latestTag string) []byte {
+	var buf bytes.Buffer
+	_, _ = fmt.Fprintf(&buf, "## %s\n\n", targetRepo)
+
+	if len(c.Added) == 0 && len(c.Updated) == 0 {
+		buf.WriteString("No new images.\n")
+		return buf.Bytes()
+	}
+
+	for _, version := range c.Added {
+		_, _ = fmt.Fprintf(&buf, "- Added %s %s\n", sourceRepo, version)
+	}
+
+	for _, version := range c.Updated {
+		_, _ = fmt.Fprintf(&buf, "- Rebuilt %s %s\n", sourceRepo, version)
+	}
+
+	if c.Latest != "" {
+		_, _ = fmt.Fprintf(&buf, "- Updated %s → %s\n", latestTag, c.Latest)
+	}
+
+	return buf.Bytes()
+}
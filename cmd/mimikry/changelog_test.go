@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRunSummaryUpdate(t *testing.T) {
+	t.Parallel()
+
+	results := []*versionResult{
+		{Version: "15.7", Status: statusPushed},
+		{Version: "16.3", Status: statusPushed},
+		{Version: "16.4", Status: statusFailed},
+	}
+
+	tests := []struct {
+		name          string
+		latestVersion string
+		previous      runSummary
+		want          changelog
+		wantLatest    string
+	}{
+		{
+			name:          "latest tagged",
+			latestVersion: "16.3",
+			previous:      runSummary{Versions: []string{"15.7"}, Latest: "15.7"},
+			want:          changelog{Added: []string{"16.3"}, Updated: []string{"15.7"}, Latest: "16.3"},
+			wantLatest:    "16.3",
+		},
+		{
+			name:          "latest not tagged",
+			latestVersion: "",
+			previous:      runSummary{Versions: []string{"15.7"}, Latest: "15.7"},
+			want:          changelog{Added: []string{"16.3"}, Updated: []string{"15.7"}},
+			wantLatest:    "15.7",
+		},
+		{
+			name:          "latest unchanged",
+			latestVersion: "16.3",
+			previous:      runSummary{Versions: []string{"15.7", "16.3"}, Latest: "16.3"},
+			want:          changelog{Updated: []string{"15.7", "16.3"}},
+			wantLatest:    "16.3",
+		},
+		{
+			name:          "latest not pushed",
+			latestVersion: "16.4",
+			previous:      runSummary{Latest: "15.7"},
+			want:          changelog{Added: []string{"15.7", "16.3"}},
+			wantLatest:    "15.7",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			summary := tt.previous
+			got := summary.update(results, tt.latestVersion)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("update() = %+v, want %+v", got, tt.want)
+			}
+
+			if summary.Latest != tt.wantLatest {
+				t.Errorf("update() recorded latest %q, want %q", summary.Latest, tt.wantLatest)
+			}
+		})
+	}
+}
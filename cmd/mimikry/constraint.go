@@ -0,0 +1,79 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// lowerBound is the smallest version a version constraint allows.
+type lowerBound struct {
+	Version   *semver.Version
+	Exclusive bool
+	Term      string // The term of the constraint setting the bound; e.g. ">=18"
+}
+
+var (
+	patternHyphenRange    = regexp.MustCompile(`(\S+)\s+-\s+(\S+)`)
+	patternConstraintTerm = regexp.MustCompile(`(>=|=>|<=|=<|!=|~>|>|<|~|\^|=)?\s*v?([0-9xX*]+(?:\.[0-9xX*]+){0,2}(?:-[0-9A-Za-z.-]+)?(?:\+[0-9A-Za-z.-]+)?)`)
+	patternWildcard       = regexp.MustCompile(`[xX*]`)
+)
+
+// constraintLowerBound returns the lower bound of the given version constraint; e.g. 18 for ">= 18, < 20". It returns
+// false if the constraint, or any of its ||-separated alternatives, has no lower bound, like "< 16" or "*".
+func constraintLowerBound(constraint string) (lowerBound, bool) {
+	var bound lowerBound
+	for i, alternative := range strings.Split(constraint, "||") {
+		// A hyphen range, e.g. "1.2 - 1.4", is short for ">= 1.2, <= 1.4"
+		alternative = patternHyphenRange.ReplaceAllString(alternative, ">=$1, <=$2")
+
+		alternativeBound, ok := termsLowerBound(alternative)
+		if !ok {
+			return lowerBound{}, false
+		}
+
+		if i == 0 || alternativeBound.Version.LessThan(bound.Version) {
+			bound = alternativeBound
+		}
+	}
+
+	return bound, bound.Version != nil
+}
+
+// termsLowerBound returns the highest lower bound of the given terms, which all need to be satisfied.
+func termsLowerBound(terms string) (lowerBound, bool) {
+	var bound lowerBound
+	for _, match := range patternConstraintTerm.FindAllStringSubmatch(terms, -1) {
+		operator, rawVersion := match[1], match[2]
+		switch operator {
+		case "<", "<=", "=<", "!=":
+			continue
+		}
+
+		// Wildcards allow anything from zero on; a leading one makes the term unbounded
+		if patternWildcard.MatchString(strings.Split(rawVersion, ".")[0]) {
+			continue
+		}
+
+		version, err := semver.NewVersion(patternWildcard.ReplaceAllString(rawVersion, "0"))
+		if err != nil {
+			continue
+		}
+
+		if bound.Version == nil || version.GreaterThan(bound.Version) {
+			bound = lowerBound{Version: version, Exclusive: operator == ">", Term: operator + rawVersion}
+		}
+	}
+
+	return bound, bound.Version != nil
+}
+
+// exceeds reports whether the bound excludes the given version and, with it, every lower version.
+func (b lowerBound) exceeds(version *semver.Version) bool {
+	if b.Exclusive {
+		return !b.Version.LessThan(version)
+	}
+
+	return b.Version.GreaterThan(version)
+}
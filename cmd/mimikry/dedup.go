@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/nikoksr/simplog"
+
+	"github.com/nikoksr/mimikry/pkg/docker"
+)
+
+// dedupVersions groups versions whose source images resolve to the same registry digest, e.g. postgres:16 and
+// postgres:16.4. The first version of each group in the given order is the canonical one; it gets built and is tagged
+// for all its aliases. It returns a map from alias to canonical version and a map from canonical version to aliases.
+// Versions whose digest can't be resolved are treated as unique.
+func dedupVersions(ctx context.Context, images docker.ImageClient, sourceRepo string, versions []*semver.Version) (map[string]string, map[string][]string) {
+	logger := simplog.FromContext(ctx)
+
+	aliasOf := make(map[string]string)
+	aliases := make(map[string][]string)
+	canonicalByDigest := make(map[string]string, len(versions))
+
+	for _, version := range versions {
+		sourceRef := fmt.Sprintf("%s:%s", sourceRepo, version.Original())
+		digest, err := images.RemoteDigest(ctx, sourceRef)
+		if err != nil {
+			logger.Warnf("Failed to resolve digest of %s; building it separately: %v", sourceRef, err)
+			continue
+		}
+
+		canonical, ok := canonicalByDigest[digest]
+		if !ok {
+			canonicalByDigest[digest] = version.Original()
+			continue
+		}
+
+		logger.Debugf("Version %s is identical to version %s (%s)", version.Original(), canonical, digest)
+		aliasOf[version.Original()] = canonical
+		aliases[canonical] = append(aliases[canonical], version.Original())
+	}
+
+	return aliasOf, aliases
+}
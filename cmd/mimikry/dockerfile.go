@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/semver/v3"
+
+	"github.com/nikoksr/mimikry/pkg/docker"
+)
+
+// parseFromInstructions returns the image references of all FROM instructions in the given Dockerfile, in order of
+// appearance. Flags like --platform and stage names are stripped.
+func parseFromInstructions(dockerfile []byte) []string {
+	var refs []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(dockerfile))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+
+		for _, field := range fields[1:] {
+			if strings.HasPrefix(field, "--") {
+				continue
+			}
+
+			refs = append(refs, field)
+			break
+		}
+	}
+
+	return refs
+}
+
+// splitImageRef splits an image reference into its repository and tag. Digests are dropped; the tag is empty if the
+// reference has none.
+func splitImageRef(ref string) (string, string) {
+	ref, _, _ = strings.Cut(ref, "@")
+
+	idx := strings.LastIndex(ref, ":")
+	if idx <= strings.LastIndex(ref, "/") {
+		return ref, ""
+	}
+
+	return ref[:idx], ref[idx+1:]
+}
+
+// checkFromVersion verifies that the first FROM instruction of the given Dockerfile uses a tag matching the version
+// being built. Variants of the version, like 16.1-alpine, are considered compatible. References that can't be checked
+// statically, e.g. because they use build args, are accepted.
+func checkFromVersion(dockerfile []byte, version *semver.Version) error {
+	refs := parseFromInstructions(dockerfile)
+	if len(refs) == 0 {
+		return fmt.Errorf("no FROM instruction found")
+	}
+
+	ref := refs[0]
+	if strings.Contains(ref, "$") {
+		return nil
+	}
+
+	_, tag := splitImageRef(ref)
+	want := version.Original()
+	if tag == want || strings.HasPrefix(tag, want+"-") {
+		return nil
+	}
+
+	return fmt.Errorf("FROM %s does not match version %s", ref, want)
+}
+
+// parseSourceRepoFromTemplate returns the repository of the image the first FROM instruction of the Dockerfile template
+// builds on; e.g. postgres for "FROM postgres:{{ .Version }}". The template is read as written, not rendered. It fails
+// if there is no Dockerfile template or FROM instruction, or if the repository is templated or taken from a build arg.
+func parseSourceRepoFromTemplate(tmpl *template.Template) (string, error) {
+	dockerfile := tmpl.Lookup("Dockerfile")
+	if dockerfile == nil || dockerfile.Tree == nil {
+		return "", errors.New("no Dockerfile template")
+	}
+
+	refs := parseFromInstructions([]byte(dockerfile.Tree.Root.String()))
+	if len(refs) == 0 {
+		return "", errors.New("no FROM instruction found in Dockerfile template")
+	}
+
+	repo, _ := splitImageRef(refs[0])
+	if repo == "" || strings.ContainsAny(repo, "{}$") {
+		return "", fmt.Errorf("FROM %s has no static repository", refs[0])
+	}
+
+	return normalizeSourceRepo(repo), nil
+}
+
+// normalizeSourceRepo returns the short form of the given Docker Hub repository, as used for tag lookups and the tag
+// cache; e.g. postgres for docker.io/library/postgres. Repositories on other registries are returned as they are.
+func normalizeSourceRepo(repo string) string {
+	if docker.RegistryHost(repo) != "docker.io" {
+		return repo
+	}
+
+	if host, path, found := strings.Cut(repo, "/"); found && strings.Contains(host, ".") {
+		repo = path
+	}
+
+	return strings.TrimPrefix(repo, "library/")
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/nikoksr/simplog"
+)
+
+type (
+	// ReleaseCycle is a single release cycle of a product, e.g. PostgreSQL 16.
+	ReleaseCycle struct {
+		Cycle string
+		EOL   bool      // Whether the cycle has reached its end-of-life at the time of the lookup
+		Date  time.Time // The end-of-life date; zero if unknown
+	}
+
+	// EOLProvider provides the release cycles and their end-of-life state for a product.
+	EOLProvider interface {
+		Cycles(ctx context.Context, product string) ([]ReleaseCycle, error)
+	}
+
+	// endOfLifeDateProvider is an EOLProvider backed by the endoflife.date API.
+	endOfLifeDateProvider struct {
+		client  *http.Client
+		baseURL string
+	}
+
+	endOfLifeDateCycle struct {
+		Cycle json.RawMessage `json:"cycle"`
+		EOL   json.RawMessage `json:"eol"`
+	}
+)
+
+var (
+	_ EOLProvider = (*endOfLifeDateProvider)(nil)
+
+	// errUnknownProduct is returned by EOLProviders for products they don't track.
+	errUnknownProduct = errors.New("unknown product")
+
+	// endOfLifeDateProducts maps docker image names to their product names on endoflife.date, where they differ.
+	endOfLifeDateProducts = map[string]string{
+		"postgres": "postgresql",
+		"node":     "nodejs",
+		"mongo":    "mongodb",
+		"httpd":    "apache",
+	}
+)
+
+func newEndOfLifeDateProvider() *endOfLifeDateProvider {
+	return &endOfLifeDateProvider{
+		client:  &http.Client{Timeout: 30 * time.Second},
+		baseURL: "https://endoflife.date/api",
+	}
+}
+
+// eolProduct returns the endoflife.date product name for the given docker image. Only the image name counts, not its
+// registry or namespace; e.g. ghcr.io/acme/postgres is postgresql as well.
+func eolProduct(image string) string {
+	name := image[strings.LastIndex(image, "/")+1:]
+	if product, ok := endOfLifeDateProducts[name]; ok {
+		return product
+	}
+
+	return name
+}
+
+func (p *endOfLifeDateProvider) Cycles(ctx context.Context, product string) ([]ReleaseCycle, error) {
+	endpoint := fmt.Sprintf("%s/%s.json", p.baseURL, url.PathEscape(product))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w %q", errUnknownProduct, product)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status for product %q: %s", product, resp.Status)
+	}
+
+	var rawCycles []endOfLifeDateCycle
+	if err = json.NewDecoder(resp.Body).Decode(&rawCycles); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+
+	now := time.Now()
+	cycles := make([]ReleaseCycle, 0, len(rawCycles))
+	for _, raw := range rawCycles {
+		// The cycle is usually a string but may be a plain number
+		name := strings.Trim(string(raw.Cycle), `"`)
+
+		// The EOL field is either a boolean or a date
+		cycle := ReleaseCycle{Cycle: name}
+		var isEOL bool
+		var date string
+		if err := json.Unmarshal(raw.EOL, &isEOL); err == nil {
+			cycle.EOL = isEOL
+		} else if err := json.Unmarshal(raw.EOL, &date); err == nil {
+			cycle.Date, err = time.Parse(time.DateOnly, date)
+			if err != nil {
+				return nil, fmt.Errorf("parse eol date of cycle %q: %w", name, err)
+			}
+			cycle.EOL = !cycle.Date.After(now)
+		}
+
+		cycles = append(cycles, cycle)
+	}
+
+	return cycles, nil
+}
+
+// cycleForVersion returns the release cycle the given version belongs to. Cycles are matched by major version, or by
+// major and minor version if the cycle has a minor component, e.g. PostgreSQL 9.6.
+func cycleForVersion(cycles []ReleaseCycle, version *semver.Version) (ReleaseCycle, bool) {
+	for _, cycle := range cycles {
+		parts := strings.Split(cycle.Cycle, ".")
+		if parts[0] != fmt.Sprint(version.Major()) {
+			continue
+		}
+
+		if len(parts) > 1 && parts[1] != fmt.Sprint(version.Minor()) {
+			continue
+		}
+
+		return cycle, true
+	}
+
+	return ReleaseCycle{}, false
+}
+
+// filterEOLVersions removes all versions whose release cycle has reached its end-of-life. Versions without a known
+// release cycle are kept.
+func filterEOLVersions(ctx context.Context, provider EOLProvider, image string, versions []*semver.Version) ([]*semver.Version, error) {
+	logger := simplog.FromContext(ctx)
+
+	cycles, err := provider.Cycles(ctx, eolProduct(image))
+	if err != nil {
+		return nil, fmt.Errorf("get release cycles: %w", err)
+	}
+
+	filtered := make([]*semver.Version, 0, len(versions))
+	for _, version := range versions {
+		cycle, ok := cycleForVersion(cycles, version)
+		if !ok {
+			logger.Debugf("Keeping version %s; no release cycle found", version.Original())
+		} else if cycle.EOL {
+			logger.Debugf("Skipping version %s; release cycle %s is end-of-life", version.Original(), cycle.Cycle)
+			continue
+		}
+
+		filtered = append(filtered, version)
+	}
+
+	return filtered, nil
+}
+
+// supportedConstraint derives a version constraint covering all currently supported release cycles of the given image,
+// e.g. ">= 12" if PostgreSQL 12 is the oldest cycle that hasn't reached its end-of-life. Newer cycles are assumed to be
+// supported as well.
+func supportedConstraint(ctx context.Context, provider EOLProvider, image string) (string, error) {
+	product := eolProduct(image)
+	cycles, err := provider.Cycles(ctx, product)
+	if err != nil {
+		return "", fmt.Errorf("get release cycles: %w", err)
+	}
+
+	var oldest *semver.Version
+	for _, cycle := range cycles {
+		if cycle.EOL {
+			continue
+		}
+
+		version, err := semver.NewVersion(cycle.Cycle)
+		if err != nil {
+			simplog.FromContext(ctx).Debugf("Ignoring release cycle %q; not a version", cycle.Cycle)
+			continue
+		}
+
+		if oldest == nil || version.LessThan(oldest) {
+			oldest = version
+		}
+	}
+
+	if oldest == nil {
+		return "", fmt.Errorf("no supported release cycle found for product %q", product)
+	}
+
+	return ">= " + oldest.Original(), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestEOLProduct(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "postgres", want: "postgresql"},
+		{image: "library/postgres", want: "postgresql"},
+		{image: "docker.io/library/node", want: "nodejs"},
+		{image: "ghcr.io/acme/postgres", want: "postgresql"},
+		{image: "localhost:5000/mongo", want: "mongodb"},
+		{image: "bitnami/redis", want: "redis"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.image, func(t *testing.T) {
+			t.Parallel()
+
+			if got := eolProduct(tt.image); got != tt.want {
+				t.Errorf("eolProduct(%q) = %q, want %q", tt.image, got, tt.want)
+			}
+		})
+	}
+}
+
+// staticEOLProvider provides fixed release cycles for a single product.
+type staticEOLProvider struct {
+	product string
+	cycles  []ReleaseCycle
+}
+
+func (p *staticEOLProvider) Cycles(_ context.Context, product string) ([]ReleaseCycle, error) {
+	if product != p.product {
+		return nil, errUnknownProduct
+	}
+
+	return p.cycles, nil
+}
+
+func TestFilterEOLVersions(t *testing.T) {
+	t.Parallel()
+
+	provider := &staticEOLProvider{product: "postgresql", cycles: []ReleaseCycle{
+		{Cycle: "16"}, {Cycle: "12", EOL: true}, {Cycle: "9.6", EOL: true},
+	}}
+	versions := parseVersions(t, "9.6.24", "10.23", "12.19", "16.3")
+
+	got, err := filterEOLVersions(context.Background(), provider, "ghcr.io/acme/postgres", versions)
+	if err != nil {
+		t.Fatalf("filterEOLVersions() error = %v", err)
+	}
+
+	var kept []string
+	for _, version := range got {
+		kept = append(kept, version.Original())
+	}
+
+	// 10 has no known release cycle, so it's kept
+	if want := []string{"10.23", "16.3"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("filterEOLVersions() = %v, want %v", kept, want)
+	}
+
+	if _, err = filterEOLVersions(context.Background(), provider, "acme/unknown", versions); !errors.Is(err, errUnknownProduct) {
+		t.Errorf("filterEOLVersions() of unknown product error = %v, want %v", err, errUnknownProduct)
+	}
+}
+
+func TestEndOfLifeDateProviderUnknownProduct(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+
+	provider := newEndOfLifeDateProvider()
+	provider.baseURL = server.URL
+
+	if _, err := provider.Cycles(context.Background(), "unknown"); !errors.Is(err, errUnknownProduct) {
+		t.Errorf("Cycles() error = %v, want %v", err, errUnknownProduct)
+	}
+}
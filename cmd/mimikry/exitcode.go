@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nikoksr/mimikry/pkg/docker"
+)
+
+// Exit codes reflecting the outcome of a run, so pipelines can react to them. Every other error exits with
+// exitFailure.
+const (
+	exitSuccess           = 0
+	exitFailure           = 1
+	exitPartialFailure    = 2 // Some versions failed with --no-fail-fast, the others succeeded
+	exitNothingToDo       = 3 // Every version was skipped; nothing was pushed, or built in dry run mode
+	exitAuthFailed        = 4
+	exitDaemonUnreachable = 5
+)
+
+// exitCodeFor returns the exit code for the given error of a run.
+func exitCodeFor(err error) int {
+	switch {
+	case err == nil:
+		return exitSuccess
+	case errors.Is(err, ErrSomeVersionsFailed):
+		return exitPartialFailure
+	case errors.Is(err, ErrNothingToDo):
+		return exitNothingToDo
+	case errors.Is(err, docker.ErrAuthFailed):
+		return exitAuthFailed
+	case errors.Is(err, docker.ErrDaemonUnreachable):
+		return exitDaemonUnreachable
+	default:
+		return exitFailure
+	}
+}
+
+// versionFailuresError returns the error of a run in which the given versions failed out of the given number of
+// versions. It's only a partial failure if some versions didn't fail.
+func versionFailuresError(failures []error, numVersions int) error {
+	if len(failures) < numVersions {
+		return fmt.Errorf("%w: %d of %d versions failed: %w", ErrSomeVersionsFailed, len(failures), numVersions, errors.Join(failures...))
+	}
+
+	return fmt.Errorf("all %d versions failed: %w", numVersions, errors.Join(failures...))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/nikoksr/mimikry/pkg/docker"
+)
+
+func TestExitCodeFor(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "success", err: nil, want: exitSuccess},
+		{name: "failure", err: errors.New("boom"), want: exitFailure},
+		{name: "partial failure", err: fmt.Errorf("%w: 1 of 2 versions failed", ErrSomeVersionsFailed), want: exitPartialFailure},
+		{name: "nothing to do", err: ErrNothingToDo, want: exitNothingToDo},
+		{name: "nothing pushed with --fail-on-empty-push", err: ErrNothingPushed, want: exitFailure},
+		{name: "auth failed", err: fmt.Errorf("push: %w", docker.ErrAuthFailed), want: exitAuthFailed},
+		{name: "daemon unreachable", err: fmt.Errorf("create docker client: %w", docker.ErrDaemonUnreachable), want: exitDaemonUnreachable},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := exitCodeFor(tt.err); got != tt.want {
+				t.Errorf("exitCodeFor(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionFailuresError(t *testing.T) {
+	t.Parallel()
+
+	failures := []error{errors.New("version 16.3: boom"), errors.New("version 16.4: boom")}
+
+	tests := []struct {
+		name        string
+		failures    []error
+		numVersions int
+		want        int
+	}{
+		{name: "some versions failed", failures: failures[:1], numVersions: 2, want: exitPartialFailure},
+		{name: "all versions failed", failures: failures, numVersions: 2, want: exitFailure},
+		{name: "single version failed", failures: failures[:1], numVersions: 1, want: exitFailure},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := versionFailuresError(tt.failures, tt.numVersions)
+			if got := exitCodeFor(err); got != tt.want {
+				t.Errorf("exitCodeFor(%v) = %d, want %d", err, got, tt.want)
+			}
+
+			for _, failure := range tt.failures {
+				if !errors.Is(err, failure) {
+					t.Errorf("versionFailuresError() = %v, doesn't wrap %v", err, failure)
+				}
+			}
+		})
+	}
+}
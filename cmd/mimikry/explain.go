@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/nikoksr/simplog"
+)
+
+type (
+	// tagDecisions records why each source tag was selected for a run or not. The decisions are logged as they are
+	// made and printed as a whole by --explain.
+	tagDecisions struct {
+		tags    []string
+		reasons map[string]string // Empty for selected tags
+		invalid []ignoredTag
+	}
+
+	// ignoredTag is a source tag that looks like a version, but couldn't be parsed as one.
+	ignoredTag struct {
+		Tag    string
+		Reason string
+	}
+)
+
+func newTagDecisions() *tagDecisions {
+	return &tagDecisions{reasons: make(map[string]string)}
+}
+
+// selectTag records that the given tag has been selected.
+func (d *tagDecisions) selectTag(ctx context.Context, tag string) {
+	simplog.FromContext(ctx).Debugf("Adding version %s", tag)
+
+	if _, ok := d.reasons[tag]; !ok {
+		d.tags = append(d.tags, tag)
+	}
+	d.reasons[tag] = ""
+}
+
+// skipTag records that the given tag has been skipped for the given reason.
+func (d *tagDecisions) skipTag(ctx context.Context, tag, reason string) {
+	simplog.FromContext(ctx).Debugf("Skipping version %s; %s", tag, reason)
+
+	if _, ok := d.reasons[tag]; !ok {
+		d.tags = append(d.tags, tag)
+	}
+	d.reasons[tag] = reason
+}
+
+// skipInvalid records that the given tag has been skipped because it couldn't be parsed as version. Such tags are
+// collected, so they can be reported at the end of the run.
+func (d *tagDecisions) skipInvalid(ctx context.Context, tag string, err error) {
+	simplog.FromContext(ctx).Warnf("Failed to parse tag %s: %v", tag, err)
+
+	reason := fmt.Sprintf("invalid version: %v", err)
+	d.skipTag(ctx, tag, reason)
+	d.invalid = append(d.invalid, ignoredTag{Tag: tag, Reason: reason})
+}
+
+// skipDropped records the versions of before that are missing in after as skipped for the given reason; used for the
+// filters working on whole version lists.
+func (d *tagDecisions) skipDropped(ctx context.Context, before, after []*semver.Version, reason string) {
+	for _, version := range before {
+		if !slices.Contains(after, version) {
+			d.skipTag(ctx, version.Original(), reason)
+		}
+	}
+}
+
+// print writes one line per tag to w, telling whether it has been selected and, if not, why.
+func (d *tagDecisions) print(w io.Writer) {
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	selected := 0
+	for _, tag := range d.tags {
+		if reason := d.reasons[tag]; reason != "" {
+			_, _ = fmt.Fprintf(writer, "%s\tskipped\t%s\n", tag, reason)
+			continue
+		}
+
+		selected++
+		_, _ = fmt.Fprintf(writer, "%s\tselected\n", tag)
+	}
+	_ = writer.Flush()
+
+	_, _ = fmt.Fprintf(w, "%d of %d tags selected\n", selected, len(d.tags))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// checkWritable creates the given directory if needed and verifies that files can be created in it.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("directory %s is not writable: %w", dir, err)
+	}
+
+	file, err := os.CreateTemp(dir, ".mimikry-write-check-")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %w", dir, err)
+	}
+	_ = file.Close()
+
+	return os.Remove(file.Name())
+}
+
+// writeFileAtomic writes the given content to a temporary file next to path and renames it into place, so readers
+// never observe a partially written file. The file gets the given permissions.
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	file, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	tmpPath := file.Name()
+
+	// Remove the temporary file on failure; after a successful rename this is a no-op
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	if _, err = file.Write(content); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("write temporary file: %w", err)
+	}
+
+	if err = file.Chmod(perm); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("chmod temporary file: %w", err)
+	}
+
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("sync temporary file: %w", err)
+	}
+
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("close temporary file: %w", err)
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename temporary file: %w", err)
+	}
+
+	return nil
+}
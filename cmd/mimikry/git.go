@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/nikoksr/simplog"
+)
+
+// splitGitSource splits a git template source of the form URL[@REF] into its URL and ref. The ref is split off at the
+// last '@' after the host, so the user of URLs like git@github.com:me/templates or https://token@github.com/me/templates
+// is kept, and refs may contain slashes, like release/1.x.
+func splitGitSource(source string) (string, string) {
+	path := gitSourcePath(source)
+	idx := strings.LastIndex(path, "@")
+	if idx < 0 {
+		return source, ""
+	}
+	idx += len(source) - len(path)
+
+	return source[:idx], source[idx+1:]
+}
+
+// gitSourcePath returns the part of the given git URL following its host; the whole source if it has no host, like
+// local paths.
+func gitSourcePath(source string) string {
+	// URLs with a scheme; the host ends with the first '/' after it
+	if _, rest, ok := strings.Cut(source, "://"); ok {
+		if idx := strings.Index(rest, "/"); idx >= 0 {
+			return rest[idx:]
+		}
+
+		return ""
+	}
+
+	// SCP-like URLs, e.g. git@github.com:me/templates; the host ends with the first ':' before any '/'
+	if idx := strings.Index(source, ":"); idx >= 0 && !strings.Contains(source[:idx], "/") {
+		return source[idx+1:]
+	}
+
+	return source
+}
+
+// gitURLUserinfo returns the credentials part of the given git URL, e.g. "token" of https://token@github.com/me/t;
+// empty if it has none. The user of SCP-like URLs is no secret and not returned.
+func gitURLUserinfo(rawURL string) string {
+	_, rest, ok := strings.Cut(rawURL, "://")
+	if !ok {
+		return ""
+	}
+
+	host, _, _ := strings.Cut(rest, "/")
+	if idx := strings.LastIndex(host, "@"); idx >= 0 {
+		return host[:idx]
+	}
+
+	return ""
+}
+
+// redactGitCredentials replaces the credentials in the given text, e.g. a git URL or git's output about it, with "xxxxx".
+func redactGitCredentials(text, rawURL string) string {
+	if userinfo := gitURLUserinfo(rawURL); userinfo != "" {
+		return strings.ReplaceAll(text, userinfo, "xxxxx")
+	}
+
+	return text
+}
+
+// cloneTemplates shallow-clones the given git template source into a temporary directory and returns its path. The
+// ref may be a branch, tag or commit; without one, the default branch is cloned. The caller is responsible for
+// removing the directory.
+func cloneTemplates(ctx context.Context, source string) (string, error) {
+	logger := simplog.FromContext(ctx)
+
+	url, ref := splitGitSource(source)
+	if url == "" {
+		return "", fmt.Errorf("invalid git template source %q", redactGitCredentials(source, source))
+	}
+
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	dir, err := os.MkdirTemp("", "mimikry-templates-")
+	if err != nil {
+		return "", fmt.Errorf("create clone directory: %w", err)
+	}
+
+	logger.Debugf("Cloning templates from %s (ref: %q) into %s", redactGitCredentials(url, url), ref, dir)
+
+	// Unlike git clone --branch, fetching the ref by itself also works for commits
+	steps := [][]string{
+		{"init", "--quiet", dir},
+		{"-C", dir, "fetch", "--quiet", "--depth", "1", "--", url, ref},
+		{"-C", dir, "checkout", "--quiet", "FETCH_HEAD"},
+	}
+	for _, args := range steps {
+		// #nosec G204 -- The arguments are passed directly to git, not through a shell.
+		output, err := exec.CommandContext(ctx, "git", args...).CombinedOutput()
+		if err != nil {
+			_ = os.RemoveAll(dir)
+
+			// Git may echo the URL, credentials included
+			message := redactGitCredentials(strings.TrimSpace(string(output)), url)
+
+			return "", fmt.Errorf("clone %s at %s: %w: %s", redactGitCredentials(url, url), ref, err, message)
+		}
+	}
+
+	return dir, nil
+}
+
+// gitRevision returns the commit checked out in the git working tree containing the given directory.
+func gitRevision(ctx context.Context, dir string) (string, error) {
+	// #nosec G204 -- The arguments are passed directly to git, not through a shell.
+	output, err := exec.CommandContext(ctx, "git", "-C", dir, "rev-parse", "HEAD").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse in %s: %w: %s", dir, err, strings.TrimSpace(string(output)))
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
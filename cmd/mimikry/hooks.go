@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"text/template"
+
+	"github.com/nikoksr/simplog"
+)
+
+// hookData is the data passed to command hook templates; e.g. "deploy.sh {{ .Tag }}". Path is the rendered
+// Dockerfile, only set before building; the other fields but Version are only set after pushing.
+type hookData struct {
+	Version string
+	Path    string
+	Tag     string
+	Tags    []string
+	Digest  string
+}
+
+// parseCommandHook parses the given command as template.
+func parseCommandHook(name, command string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(command)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", name, err)
+	}
+
+	return tmpl, nil
+}
+
+// shellCommand returns the command running the given command line in the platform's shell.
+func shellCommand(ctx context.Context, commandLine string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", commandLine)
+	}
+
+	return exec.CommandContext(ctx, "sh", "-c", commandLine)
+}
+
+// runCommandHook renders the command hook with the given data and runs it in the shell. Its output is logged line by
+// line.
+func runCommandHook(ctx context.Context, hook *template.Template, data hookData) error {
+	logger := simplog.FromContext(ctx)
+
+	var commandLine bytes.Buffer
+	if err := hook.Execute(&commandLine, data); err != nil {
+		return fmt.Errorf("render %s: %w", hook.Name(), err)
+	}
+
+	logger.Debugf("Running %s: %s", hook.Name(), commandLine.String())
+
+	output, err := shellCommand(ctx, commandLine.String()).CombinedOutput()
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		logger.Infof("[%s] %s", hook.Name(), scanner.Text())
+	}
+
+	if err != nil {
+		return fmt.Errorf("run %s: %w", hook.Name(), err)
+	}
+
+	return nil
+}
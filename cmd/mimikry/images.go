@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/nikoksr/simplog"
+	"github.com/spf13/pflag"
+
+	"github.com/nikoksr/mimikry/pkg/docker"
+)
+
+func printImagesHelp(flags *pflag.FlagSet) func() {
+	return func() {
+		_, _ = fmt.Fprint(os.Stderr, `Usage:
+
+  mimikry images list [OPTIONS]
+  mimikry images prune --label KEY[=VALUE]... [OPTIONS]
+
+Lists or removes the local images matching all given label selectors; e.g. --label maintainer=me --label
+org.opencontainers.image.created. A selector without a value matches any value. Pruning requires at least one
+selector.
+
+Options:
+
+`)
+
+		flags.PrintDefaults()
+	}
+}
+
+// runImagesCommand runs the images subcommand with the given arguments, i.e. everything after "mimikry images".
+func runImagesCommand(ctx context.Context, args []string) error {
+	flags := pflag.NewFlagSet("images", pflag.ContinueOnError)
+	debug := flags.Bool("debug", false, "Enable debug mode")
+	dockerHost := flags.String("docker-host", "", "Address of the docker daemon; e.g. tcp://builder:2376. Overrides DOCKER_HOST")
+	labels := flags.StringArray("label", nil, "Only select images with the given label; e.g. \"maintainer=me\". Can be repeated, images must match all labels")
+	dryRun := flags.Bool("dry-run", false, "Only print the images prune would remove")
+	flags.Usage = printImagesHelp(flags)
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+
+		return err
+	}
+
+	if flags.NArg() != 1 || (flags.Arg(0) != "list" && flags.Arg(0) != "prune") {
+		flags.Usage()
+		return errors.New("missing arguments; expected: mimikry images list|prune")
+	}
+
+	prune := flags.Arg(0) == "prune"
+	if prune && len(*labels) == 0 {
+		return errors.New("prune requires at least one --label")
+	}
+
+	logger := simplog.NewClientLogger(*debug)
+	ctx = simplog.WithLogger(ctx, logger)
+
+	var clientOpts []docker.Option
+	if *dockerHost != "" {
+		if err := docker.ValidateHost(*dockerHost); err != nil {
+			return err
+		}
+		clientOpts = append(clientOpts, docker.WithHost(*dockerHost))
+	}
+
+	client, err := docker.New(ctx, clientOpts...)
+	if err != nil {
+		return fmt.Errorf("create docker client: %w", err)
+	}
+	defer func() { _ = client.Close(ctx) }()
+
+	images, err := client.Images().List(ctx, *labels...)
+	if err != nil {
+		return err
+	}
+
+	printImages(images)
+
+	if !prune || *dryRun || len(images) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(images))
+	for _, summary := range images {
+		ids = append(ids, summary.ID)
+	}
+
+	logger.Infof("Removing %d images", len(ids))
+
+	return client.Images().Remove(ctx, ids...)
+}
+
+// printImages prints the given images as table to stdout.
+func printImages(images []image.Summary) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "IMAGE ID\tTAGS\tCREATED\tSIZE")
+	for _, summary := range images {
+		created := time.Unix(summary.Created, 0).Format(time.DateTime)
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", shortID(summary.ID), strings.Join(summary.RepoTags, ", "), created, humanSize(summary.Size))
+	}
+	_ = writer.Flush()
+}
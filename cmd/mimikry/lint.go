@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+type (
+	// dockerfileInstruction is a single, logical Dockerfile instruction with line continuations resolved.
+	dockerfileInstruction struct {
+		Line      int    // Line the instruction starts at
+		Command   string // Upper-cased, e.g. RUN
+		Arguments string
+	}
+
+	// lintRule is an embedded Dockerfile lint rule, modeled after the hadolint rule of the same ID.
+	lintRule struct {
+		ID      string
+		Message string
+		Check   func(instruction dockerfileInstruction, stages map[string]bool) bool // Reports whether the rule is violated
+	}
+
+	lintFinding struct {
+		Rule    string
+		Line    int
+		Message string
+	}
+
+	hadolintFinding struct {
+		Code    string `json:"code"`
+		Line    int    `json:"line"`
+		Message string `json:"message"`
+	}
+)
+
+var lintRules = []lintRule{
+	{
+		ID:      "DL3006",
+		Message: "Always tag the version of an image explicitly",
+		Check: func(instruction dockerfileInstruction, stages map[string]bool) bool {
+			ref := fromImage(instruction)
+			if ref == "" || ref == "scratch" || stages[ref] || strings.Contains(ref, "$") {
+				return false
+			}
+
+			_, tag := splitImageRef(ref)
+
+			return tag == "" && !strings.Contains(ref, "@")
+		},
+	},
+	{
+		ID:      "DL3007",
+		Message: "Using latest is prone to errors if the image will ever update; pin the version explicitly",
+		Check: func(instruction dockerfileInstruction, _ map[string]bool) bool {
+			_, tag := splitImageRef(fromImage(instruction))
+
+			return tag == "latest"
+		},
+	},
+	{
+		ID:      "DL3005",
+		Message: "Do not use apt-get upgrade or dist-upgrade",
+		Check: func(instruction dockerfileInstruction, _ map[string]bool) bool {
+			return instruction.Command == "RUN" &&
+				(strings.Contains(instruction.Arguments, "apt-get upgrade") ||
+					strings.Contains(instruction.Arguments, "apt-get dist-upgrade"))
+		},
+	},
+	{
+		ID:      "DL3009",
+		Message: "Delete the apt-get lists after installing something",
+		Check: func(instruction dockerfileInstruction, _ map[string]bool) bool {
+			return instruction.Command == "RUN" &&
+				strings.Contains(instruction.Arguments, "apt-get install") &&
+				!strings.Contains(instruction.Arguments, "/var/lib/apt/lists")
+		},
+	},
+	{
+		ID:      "DL3015",
+		Message: "Avoid additional packages by specifying --no-install-recommends",
+		Check: func(instruction dockerfileInstruction, _ map[string]bool) bool {
+			return instruction.Command == "RUN" &&
+				strings.Contains(instruction.Arguments, "apt-get install") &&
+				!strings.Contains(instruction.Arguments, "--no-install-recommends")
+		},
+	},
+	{
+		ID:      "DL3004",
+		Message: "Do not use sudo as it leads to unpredictable behavior; use a tool like gosu to enforce root",
+		Check: func(instruction dockerfileInstruction, _ map[string]bool) bool {
+			return instruction.Command == "RUN" && containsWord(instruction.Arguments, "sudo")
+		},
+	},
+	{
+		ID:      "DL4000",
+		Message: "MAINTAINER is deprecated; use a LABEL instead",
+		Check: func(instruction dockerfileInstruction, _ map[string]bool) bool {
+			return instruction.Command == "MAINTAINER"
+		},
+	},
+}
+
+// lintRuleIDs returns the IDs of all embedded lint rules.
+func lintRuleIDs() []string {
+	ids := make([]string, 0, len(lintRules))
+	for _, rule := range lintRules {
+		ids = append(ids, rule.ID)
+	}
+
+	return ids
+}
+
+func containsWord(s, word string) bool {
+	for _, field := range strings.Fields(s) {
+		if field == word {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fromImage returns the image reference of a FROM instruction; empty for other instructions.
+func fromImage(instruction dockerfileInstruction) string {
+	if instruction.Command != "FROM" {
+		return ""
+	}
+
+	refs := parseFromInstructions([]byte("FROM " + instruction.Arguments))
+	if len(refs) == 0 {
+		return ""
+	}
+
+	return refs[0]
+}
+
+// parseDockerfile splits a Dockerfile into its instructions, skipping comments and resolving line continuations.
+func parseDockerfile(dockerfile []byte) []dockerfileInstruction {
+	var instructions []dockerfileInstruction
+	var current *dockerfileInstruction
+
+	scanner := bufio.NewScanner(bytes.NewReader(dockerfile))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		continued := strings.HasSuffix(line, "\\")
+		line = strings.TrimSuffix(line, "\\")
+
+		if current == nil {
+			command, arguments, _ := strings.Cut(line, " ")
+			current = &dockerfileInstruction{Line: lineNum, Command: strings.ToUpper(command), Arguments: arguments}
+		} else {
+			current.Arguments += " " + line
+		}
+
+		if !continued {
+			instructions = append(instructions, *current)
+			current = nil
+		}
+	}
+
+	if current != nil {
+		instructions = append(instructions, *current)
+	}
+
+	return instructions
+}
+
+// lintDockerfile checks the given Dockerfile against the enabled embedded rules.
+func lintDockerfile(dockerfile []byte, enabledRules []string) []lintFinding {
+	var findings []lintFinding
+
+	stages := make(map[string]bool)
+	for _, instruction := range parseDockerfile(dockerfile) {
+		for _, rule := range lintRules {
+			if !slices.Contains(enabledRules, rule.ID) {
+				continue
+			}
+
+			if rule.Check(instruction, stages) {
+				findings = append(findings, lintFinding{Rule: rule.ID, Line: instruction.Line, Message: rule.Message})
+			}
+		}
+
+		// Remember stage names, so later FROM instructions referencing them aren't flagged as untagged
+		if fields := strings.Fields(instruction.Arguments); instruction.Command == "FROM" && len(fields) >= 3 {
+			if strings.EqualFold(fields[len(fields)-2], "AS") {
+				stages[fields[len(fields)-1]] = true
+			}
+		}
+	}
+
+	return findings
+}
+
+// hadolintAvailable reports whether hadolint is installed.
+func hadolintAvailable() bool {
+	_, err := exec.LookPath("hadolint")
+
+	return err == nil
+}
+
+// runHadolint lints the Dockerfile at the given path with hadolint.
+func runHadolint(ctx context.Context, path string) ([]lintFinding, error) {
+	// #nosec G204 -- The path is passed directly to hadolint, not through a shell.
+	output, err := exec.CommandContext(ctx, "hadolint", "--no-fail", "--format", "json", path).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("run hadolint: %w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+
+		return nil, fmt.Errorf("run hadolint: %w", err)
+	}
+
+	var rawFindings []hadolintFinding
+	if err = json.Unmarshal(output, &rawFindings); err != nil {
+		return nil, fmt.Errorf("decode hadolint output: %w", err)
+	}
+
+	findings := make([]lintFinding, 0, len(rawFindings))
+	for _, finding := range rawFindings {
+		findings = append(findings, lintFinding{Rule: finding.Code, Line: finding.Line, Message: finding.Message})
+	}
+
+	return findings, nil
+}
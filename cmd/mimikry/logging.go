@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/nikoksr/simplog"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// prefixCore prepends a fixed prefix to the message of every entry it writes. The console encoder of simplog doesn't
+// print logger names, and fields end up at the end of the line, so this is the way to lead each line with its version.
+type prefixCore struct {
+	zapcore.Core
+	prefix string
+}
+
+func (c prefixCore) With(fields []zapcore.Field) zapcore.Core {
+	return prefixCore{Core: c.Core.With(fields), prefix: c.prefix}
+}
+
+func (c prefixCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+
+	return checked
+}
+
+func (c prefixCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = c.prefix + entry.Message
+
+	return c.Core.Write(entry, fields)
+}
+
+// withVersionLogger returns a context whose logger prefixes every line with the given version, e.g. "[16.1]", so the
+// lines of concurrently processed versions can be told apart.
+func withVersionLogger(ctx context.Context, version string) context.Context {
+	logger := simplog.FromContext(ctx).Desugar().WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return prefixCore{Core: core, prefix: "[" + version + "] "}
+	}))
+
+	return simplog.WithLogger(ctx, logger.Sugar())
+}
+
+// heldLogs holds back log entries until the run decides which of them to show, so quiet runs can still log everything
+// if they turn out to have changed something.
+type heldLogs struct {
+	mu       sync.Mutex
+	entries  []heldEntry
+	released bool
+	minLevel zapcore.Level
+}
+
+type heldEntry struct {
+	core   zapcore.Core
+	entry  zapcore.Entry
+	fields []zapcore.Field
+}
+
+// holdCore holds back the entries it's given in heldLogs until they're released.
+type holdCore struct {
+	zapcore.Core
+	held *heldLogs
+}
+
+func (c holdCore) With(fields []zapcore.Field) zapcore.Core {
+	return holdCore{Core: c.Core.With(fields), held: c.held}
+}
+
+func (c holdCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+
+	return checked
+}
+
+func (c holdCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	c.held.mu.Lock()
+	defer c.held.mu.Unlock()
+
+	if !c.held.released {
+		c.held.entries = append(c.held.entries, heldEntry{core: c.Core, entry: entry, fields: slices.Clone(fields)})
+		return nil
+	}
+
+	if entry.Level < c.held.minLevel {
+		return nil
+	}
+
+	return c.Core.Write(entry, fields)
+}
+
+// holdLogs returns a logger whose entries are held back until release is called on the returned heldLogs.
+func holdLogs(logger *zap.SugaredLogger) (*zap.SugaredLogger, *heldLogs) {
+	held := &heldLogs{}
+	logger = logger.Desugar().WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return holdCore{Core: core, held: held}
+	})).Sugar()
+
+	return logger, held
+}
+
+// release writes the held entries of at least the given level and drops the others; so are all later entries. Only
+// the first release counts. It's a no-op on nil.
+func (h *heldLogs) release(minLevel zapcore.Level) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.released {
+		return
+	}
+	h.released, h.minLevel = true, minLevel
+
+	for _, held := range h.entries {
+		if held.entry.Level >= minLevel {
+			_ = held.core.Write(held.entry, held.fields)
+		}
+	}
+	h.entries = nil
+}
+
+type heldLogsKey struct{}
+
+// withHeldLogs returns a context carrying the given held logs, so the run can release them once it knows what to show.
+func withHeldLogs(ctx context.Context, held *heldLogs) context.Context {
+	return context.WithValue(ctx, heldLogsKey{}, held)
+}
+
+// heldLogsFromContext returns the held logs of the given context; nil if logs aren't held back.
+func heldLogsFromContext(ctx context.Context) *heldLogs {
+	held, _ := ctx.Value(heldLogsKey{}).(*heldLogs)
+
+	return held
+}
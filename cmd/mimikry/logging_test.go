@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func newBufferLogger(buf *bytes.Buffer) *zap.SugaredLogger {
+	encoder := zapcore.NewConsoleEncoder(zapcore.EncoderConfig{MessageKey: "msg", LevelKey: "level", EncodeLevel: zapcore.CapitalLevelEncoder})
+
+	return zap.New(zapcore.NewCore(encoder, zapcore.AddSync(buf), zapcore.DebugLevel)).Sugar()
+}
+
+func TestHeldLogs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		minLevel zapcore.Level
+		want     string
+	}{
+		{
+			name:     "changes",
+			minLevel: zapcore.DebugLevel,
+			want:     "INFO\tBuilding\nWARN\t[16.3] Slow\nINFO\tPushing\nINFO\tDone\n",
+		},
+		{
+			name:     "no changes",
+			minLevel: zapcore.WarnLevel,
+			want:     "WARN\t[16.3] Slow\n",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			logger, held := holdLogs(newBufferLogger(&buf))
+
+			logger.Info("Building")
+			logger.Desugar().WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+				return prefixCore{Core: core, prefix: "[16.3] "}
+			})).Sugar().Warn("Slow")
+			logger.Info("Pushing")
+
+			if buf.Len() != 0 {
+				t.Fatalf("logs written before release: %q", buf.String())
+			}
+
+			held.release(tt.minLevel)
+			logger.Info("Done")
+
+			// Only the first release counts
+			held.release(zapcore.DebugLevel)
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("logs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeldLogsFromContext(t *testing.T) {
+	t.Parallel()
+
+	// Without held logs, releasing is a no-op
+	held := heldLogsFromContext(context.Background())
+	if held != nil {
+		t.Fatalf("heldLogsFromContext() = %v, want nil", held)
+	}
+	held.release(zapcore.DebugLevel)
+
+	_, held = holdLogs(zap.NewNop().Sugar())
+	if got := heldLogsFromContext(withHeldLogs(context.Background(), held)); got != held {
+		t.Errorf("heldLogsFromContext() = %p, want %p", got, held)
+	}
+}
@@ -1,23 +1,32 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	_ "embed"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"runtime/debug"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/docker/docker/api/types"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/nikoksr/simplog"
 	"github.com/spf13/pflag"
+	"go.uber.org/zap/zapcore"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/nikoksr/mimikry/pkg/docker"
@@ -26,41 +35,204 @@ import (
 type (
 	templateData struct {
 		Version      string
-		Maintainer   string
+		Maintainer   string   // The first maintainer; kept for templates predating Maintainers
+		Maintainers  []string // All maintainers
+		Authors      string   // All maintainers, joined for the org.opencontainers.image.authors label
 		InstallTools bool
 		Tools        string
+		Extra        map[string]string
+		DefaultUser  string
+		ExtraEnv     map[string]string
+		Revision     string // The git commit of the templates, if known
 	}
 
 	options struct {
 		VersionConstraint string
 		TagLatest         bool
 		Maintainer        string
+		Maintainers       []string
 		TargetRepo        string
 		TemplatePath      string
 		BuildDir          string
 		DryRun            bool
 		Debug             bool
 		KeepBuildDirs     bool
+		ExportDir         string
+		LoadArchives      []string
+		FailOnEmptyPush   bool
+		UserAgent         string
+		TemplateGit       string
+		IncludeEOL        bool
+		ExcludeEOL        bool
+		AutoConstraint    bool
+		Strict            bool
+		PostBuildSnippet  string
+		Timeout           time.Duration
+		Force             bool
+		KeepImages        int
+		VarsFile          string
+		Vars              []string
+		TemplateVars      map[string]string
+		Platform          string
+		Platforms         []string
+		ReportFormat      string
+		ReportFile        string
+		AuthConfig        string
+		TemplatesFor      []string
+		TemplateMappings  []versionMapping
+		Tools             string
+		ToolsFor          []string
+		ToolMappings      []versionMapping
+		PlatformsFor      []string
+		PlatformMappings  []versionMapping
+		BaseStrategy      string
+		BuildBackend      string
+		DefaultUser       string
+		ExtraEnvPairs     []string
+		ExtraEnv          map[string]string
+		DedupTags         bool
+		Lint              bool
+		LintRules         []string
+		StrictLint        bool
+		Preset            string
+		AssertLabelPairs  []string
+		AssertLabels      map[string]string
+		AssertEnvPairs    []string
+		AssertEnv         map[string]string
+		RemoveConcurrency int
+		SourceRepos       []string
+		Source            string
+		DetectSourceRepo  bool
+		StrictSemver      bool
+		Proxy             string
+		EmitCommands      bool
+		LatestTag         string
+		DockerHost        string
+		ChangelogFile     string
+		DigestFile        string
+		VerifyPush        bool
+		RegistryRPS       float64
+		MaxTagPages       int
+		PrintVersion      bool
+		CompareBases      bool
+		StagingSuffix     string
+		NoFailFast        bool
+		BuildArgPairs     []string
+		BuildArgFile      string
+		BuildArgs         map[string]string
+		Dockerignore      string
+		CreatedFromSource bool
+		SourceDateEpoch   int64
+		SourceDate        time.Time
+		OnlyMissing       bool
+		PostPushCmd       string
+		PostPushHook      *template.Template
+		ValidateCmd       string
+		ValidateHook      *template.Template
+		FailOnHookError   bool
+		KeepBaseImages    bool
+		Versions          []string
+		ResumableFetch    bool
+		RefreshTags       bool
+		SortOrder         string
+		DefaultIgnore     bool
+		InMemoryContext   bool
+		StepTimings       bool
+		SummaryOnChange   bool
+		OnImmutable       string
+		FromBuildDir      bool
+		BuildMemory       string
+		BuildCPUs         float64
+		BuildUlimitSpecs  []string
+		BuildResources    buildResources
+		SecretSpecs       []string
+		Secrets           []docker.BuildSecret
+		SSHSpecs          []string
+		SSH               []docker.BuildSSH
+		OCI               bool
+		Provenance        string
+		ContextCompress   int
+		ContextExcludeVCS bool
+		SlackWebhook      string
+		NoCacheFile       bool
+		VersionBuildArg   string
+		RollingMinor      bool
+		RollingMajor      bool
+		Explain           bool
+		SkipUnchanged     bool
+		StrictTags        bool
+		Dockerfile        string
+		DockerfileSource  []byte
+		Reconnect         int
+		VerifyBase        bool
+		VerifyBaseKey     string
+		VerifyIdentity    string
+		VerifyIssuer      string
+		TempBuild         bool
+		RecordTags        string
+		ReplayTags        string
+		TemplateRevision  string
+		SmokeTest         string
+		SmokeTestTimeout  time.Duration
+	}
+
+	// builtImage is an image built during a run, along with the base image it was built on.
+	builtImage struct {
+		ID     string
+		BaseID string
+		// SnippetBaseID is the image the post-build snippet was applied to, if any. It lost its tags to the image
+		// and is evicted along with it.
+		SnippetBaseID string
 	}
 
 	imageTags struct {
-		Image    string    `json:"image"`
-		Modified time.Time `json:"modified"`
-		Tags     []string  `json:"tags"`
+		Image    string               `json:"image"`
+		Modified time.Time            `json:"modified"`
+		Tags     []string             `json:"tags"`
+		Pushed   map[string]time.Time `json:"tag_last_pushed,omitempty"` // Only known for some registries
+		Cursor   *tagFetchCursor      `json:"cursor,omitempty"`          // Only set while a resumable fetch is incomplete
+	}
+
+	// tagFetchCursor records where an interrupted, resumable tag fetch stopped.
+	tagFetchCursor struct {
+		Repo string `json:"repo"`
+		Next string `json:"next"` // The next page of Repo; empty if Repo hasn't been fetched at all
 	}
 )
 
 const (
-	defaultSourceRepo     = "postgres" // TODO: Needs to be extracted from Dockerfile template
+	defaultSourceRepo     = "postgres" // Used if the Dockerfile template doesn't name the source repository statically
 	defaultDockerTools    = "vim"
 	defaultMaintainer     = "Unknown"
 	defaultBuildDirectory = "./mimikry"
-	postgresCachePath     = "./.cache/mimikry/postgres.json"
+	defaultLatestTag      = "latest"
+	tagCacheDirectory     = "./.cache/mimikry"
+	runStateDirectory     = "./.cache/mimikry/runs"
+	runSummaryDirectory   = "./.cache/mimikry/summaries"
+	buildRecordDirectory  = "./.cache/mimikry/builds"
+	partialsDirectory     = "partials"
+
+	// Policies for tags the registry refuses to overwrite
+	immutableFail  = "fail"
+	immutableSkip  = "skip"
+	immutableForce = "force"
+
+	// Build orders of the versions
+	sortAsc  = "asc"
+	sortDesc = "desc"
 )
 
 var (
-	ErrNoTagCache      = errors.New("no tag cache found")
-	ErrInvalidTagCache = errors.New("invalid tag cache")
+	ErrNoTagCache         = errors.New("no tag cache found")
+	ErrInvalidTagCache    = errors.New("invalid tag cache")
+	ErrNothingPushed      = errors.New("no images were pushed")
+	ErrNothingToDo        = errors.New("nothing to do; all versions were skipped")
+	ErrSomeVersionsFailed = errors.New("partial failure")
+
+	immutablePolicies = []string{immutableFail, immutableSkip, immutableForce}
+
+	//go:embed default.dockerignore
+	defaultDockerignore []byte
 
 	patternImageTag = regexp.MustCompile(`^\d+(\.\d+)?(\.\d+)?$`) // Ignore anything that is not a major.minor version
 
@@ -108,24 +280,104 @@ func loadTagCache(path string) (*imageTags, error) {
 	return &cache, nil
 }
 
-func saveTagCache(path string, cache *imageTags) error {
-	// Create directory
-	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
-		return fmt.Errorf("create tag cache directory: %w", err)
+// tagCachePath returns the path of the tag cache for the given source repositories. Each combination of repositories
+// gets its own cache file.
+func tagCachePath(repos []string) string {
+	repos = slices.Clone(repos)
+	sort.Strings(repos)
+	repos = slices.Compact(repos)
+
+	name := strings.NewReplacer("/", "_", ":", "_").Replace(strings.Join(repos, "+"))
+
+	return filepath.FromSlash(filepath.Join(tagCacheDirectory, name+".json"))
+}
+
+// tagCacheImage returns the image identifier stored in the tag cache for the given source repositories.
+func tagCacheImage(repos []string) string {
+	return strings.Join(repos, ",")
+}
+
+// fetchRepoTags fetches the tags of all given repositories and merges them into a new tag cache, dropping duplicates.
+// If partial is an incomplete cache of an interrupted fetch, the fetch resumes where it stopped. If checkpoint is set,
+// it's called with the incomplete cache after every page, so the fetch can be resumed if it gets interrupted.
+func fetchRepoTags(ctx context.Context, repos []string, partial *imageTags, checkpoint func(*imageTags)) (*imageTags, error) {
+	cache := &imageTags{
+		Image:    tagCacheImage(repos),
+		Modified: time.Now(),
+		Pushed:   make(map[string]time.Time),
 	}
 
-	// Open file
-	file, err := os.Create(path)
-	if err != nil {
-		return fmt.Errorf("create tag cache file: %w", err)
+	start, cursor := 0, ""
+	if partial != nil && partial.Cursor != nil && partial.Image == cache.Image {
+		if idx := slices.Index(repos, partial.Cursor.Repo); idx >= 0 {
+			simplog.FromContext(ctx).Infof("Resuming tag fetch of %s with %d tags", partial.Cursor.Repo, len(partial.Tags))
+			cache.Tags = partial.Tags
+			maps.Copy(cache.Pushed, partial.Pushed)
+			start, cursor = idx, partial.Cursor.Next
+		}
 	}
-	defer func() { _ = file.Close() }()
 
-	// Encode JSON
-	if err = json.NewEncoder(file).Encode(cache); err != nil {
+	seen := make(map[string]bool, len(cache.Tags))
+	for _, tag := range cache.Tags {
+		seen[tag] = true
+	}
+
+	for idx := start; idx < len(repos); idx++ {
+		repo := repos[idx]
+		if idx > start {
+			cursor = ""
+		}
+
+		err := docker.WalkTags(ctx, repo, cursor, func(page []docker.Tag, next string) error {
+			for _, tag := range page {
+				if seen[tag.Name] {
+					continue
+				}
+
+				seen[tag.Name] = true
+				cache.Tags = append(cache.Tags, tag.Name)
+				if !tag.Pushed.IsZero() {
+					cache.Pushed[tag.Name] = tag.Pushed
+				}
+			}
+
+			if checkpoint == nil {
+				return nil
+			}
+
+			switch {
+			case next != "":
+				cache.Cursor = &tagFetchCursor{Repo: repo, Next: next}
+			case idx+1 < len(repos):
+				cache.Cursor = &tagFetchCursor{Repo: repos[idx+1]}
+			default:
+				cache.Cursor = nil
+			}
+			checkpoint(cache)
+
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("get tags of %s: %w", repo, err)
+		}
+	}
+
+	cache.Cursor = nil
+
+	return cache, nil
+}
+
+// saveTagCache writes the tag cache atomically, so concurrent readers never observe a partially written cache.
+func saveTagCache(path string, cache *imageTags) error {
+	content, err := json.Marshal(cache)
+	if err != nil {
 		return fmt.Errorf("encode tag cache: %w", err)
 	}
 
+	if err = writeFileAtomic(path, content, 0o600); err != nil {
+		return fmt.Errorf("write tag cache: %w", err)
+	}
+
 	return nil
 }
 
@@ -137,6 +389,12 @@ func printHelp() {
 	_, _ = fmt.Fprint(os.Stderr, `Usage:
 
   mimikry [OPTIONS] SOURCE-FILE TARGET-REPO
+  mimikry [OPTIONS] --template-git URL[@REF] TARGET-REPO
+  mimikry [OPTIONS] --from-build-dir TARGET-REPO
+  mimikry [build] [OPTIONS] --dockerfile FILE|- TARGET-REPO
+  mimikry cache warm [OPTIONS] SOURCE-REPO...
+  mimikry template check [OPTIONS] SOURCE-FILE
+  mimikry images list|prune [OPTIONS]
 
 Options:
 
@@ -167,307 +425,2480 @@ Example:
 func optionsFromCLI() (*options, error) {
 	var ops options
 
-	pflag.StringVarP(&ops.Maintainer, "maintainer", "m", defaultMaintainer, "The maintainer of the Dockerfile")
+	pflag.StringArrayVar(&ops.SourceRepos, "source-repo", []string{defaultSourceRepo}, "The repository whose tags are built; can be repeated to merge the tags of multiple repositories. The first one is used for end-of-life and digest lookups. Defaults to the repository of the first FROM instruction of the Dockerfile template, if it names one statically")
+	pflag.StringVarP(&ops.Source, "source", "s", "", "The repository whose tags are built; e.g. \"redis\", \"library/redis\" or \"bitnami/redis\". Overrides the repository detected in the Dockerfile template; a single-repository shorthand for --source-repo")
+	pflag.StringArrayVarP(&ops.Maintainers, "maintainer", "m", []string{defaultMaintainer}, "The maintainer of the Dockerfile; can be repeated for images with multiple maintainers")
 	pflag.StringVarP(&ops.BuildDir, "build", "b", defaultBuildDirectory, "The path to the build directory")
 	pflag.StringVarP(&ops.VersionConstraint, "version", "v", "", "Semantic version constraint; e.g. \">= 12.3\". If not set, all versions are built. See -h for more information")
+	pflag.StringVar(&ops.DefaultUser, "default-user", "", "Default user for the images, available to templates as .DefaultUser")
+	pflag.StringArrayVar(&ops.ExtraEnvPairs, "extra-env", nil, "Environment variable for the images, available to templates as .ExtraEnv; e.g. \"TZ=UTC\". Can be repeated")
+	pflag.StringVar(&ops.Tools, "tools", defaultDockerTools, "Comma-separated list of tools passed to the templates as .Tools")
+	pflag.StringArrayVar(&ops.ToolsFor, "tools-for", nil, "Use a different list of tools for a range of versions; e.g. \">=12:vim,curl\". Can be repeated; the first match wins, --tools is the fallback")
+	pflag.StringArrayVar(&ops.TemplatesFor, "template-for", nil, "Use a different template as Dockerfile for a range of versions; e.g. \">=14:Dockerfile.new\". Can be repeated; the first match wins")
+	pflag.StringArrayVar(&ops.Vars, "set", nil, "Set a template variable, available as .Extra.KEY; e.g. \"locale=de_DE\". Can be repeated")
+	pflag.Int64Var(&ops.SourceDateEpoch, "source-date-epoch", 0, "Unix timestamp for reproducible builds; passed to the build as SOURCE_DATE_EPOCH build arg and used for the org.opencontainers.image.created label and the modification times of rendered files. Defaults to the SOURCE_DATE_EPOCH environment variable")
+	pflag.BoolVar(&ops.CreatedFromSource, "created-from-source", false, "Set the org.opencontainers.image.created label to the time the source tag was last pushed instead of the build time. Only supported for Docker Hub sources")
+	pflag.BoolVar(&ops.DefaultIgnore, "default-dockerignore", false, "Write a default .dockerignore, excluding VCS directories, markdown and editor files, into every build directory without one of its own; ignored if --dockerignore is set")
+	pflag.StringVar(&ops.Dockerignore, "dockerignore", "", "Path to an ignore file applied to every build context, in addition to a rendered .dockerignore")
+	pflag.StringArrayVar(&ops.BuildArgPairs, "build-arg", nil, "Set a build arg; e.g. \"APT_MIRROR=http://mirror\". Without a value, it's taken from the environment. Can be repeated")
+	pflag.StringVar(&ops.BuildArgFile, "build-arg-file", "", "Path to an env file with build args; values set via --build-arg take precedence")
+	pflag.StringVar(&ops.VarsFile, "vars", "", "Path to a JSON or YAML file with template variables; values set via --set take precedence")
+	pflag.BoolVar(&ops.DedupTags, "dedup-tags", false, "Build versions whose source images are identical (e.g. 16 and 16.4) only once and tag the image for all of them")
+	pflag.StringSliceVar(&ops.Versions, "versions", nil, "Build exactly the given comma-separated versions, e.g. \"14.1,15.2\", instead of filtering by constraint. Each must exist in the source repository")
+	pflag.StringVar(&ops.Preset, "preset", "", "Select versions by a named preset instead of a constraint: \"latest-major\", \"supported\" (not end-of-life according to endoflife.date) or \"last-3-minors\"")
 	pflag.BoolVarP(&ops.TagLatest, "latest", "l", false, "Whether to tag the latest image as latest")
+	pflag.StringVar(&ops.LatestTag, "latest-tag", defaultLatestTag, "The name of the rolling tag set by --latest; e.g. \"stable\"")
+	pflag.BoolVar(&ops.IncludeEOL, "include-eol", false, "Keep versions that reached their end-of-life according to endoflife.date; they're skipped by default, unless listed by --versions")
+	pflag.BoolVar(&ops.ExcludeEOL, "exclude-eol", false, "Skip versions that reached their end-of-life according to endoflife.date")
+	_ = pflag.CommandLine.MarkDeprecated("exclude-eol", "end-of-life versions are skipped by default; pass --include-eol to keep them")
+	pflag.BoolVar(&ops.AutoConstraint, "auto-constraint", false, "If --version is not set, derive it from the release cycles still supported according to endoflife.date")
+	pflag.StringVar(&ops.VersionBuildArg, "version-build-arg", "", "Name of a build arg that is set to the version of each build; e.g. \"PG_VERSION\"")
+	pflag.StringVar(&ops.BuildMemory, "build-memory", "", "Memory limit of the build containers; e.g. \"4g\"")
+	pflag.Float64Var(&ops.BuildCPUs, "build-cpus", 0, "Number of CPUs available to the build containers; e.g. 1.5")
+	pflag.StringArrayVar(&ops.BuildUlimitSpecs, "build-ulimit", nil, "Ulimit of the build containers; e.g. \"nofile=1024:2048\". Can be repeated")
+	pflag.StringArrayVar(&ops.SecretSpecs, "secret", nil, "Secret exposed to RUN --mount=type=secret instructions, in the format of docker build; e.g. \"id=npmrc,src=.npmrc\" or \"id=token,env=GITHUB_TOKEN\". Needs BuildKit. Can be repeated")
+	pflag.StringArrayVar(&ops.SSHSpecs, "ssh", nil, "SSH agent socket or keys forwarded to RUN --mount=type=ssh instructions, in the format of docker build; e.g. \"default\" for the agent of SSH_AUTH_SOCK. Needs BuildKit. Can be repeated")
+	pflag.BoolVar(&ops.OCI, "oci", false, "Build and push images with OCI instead of Docker media types. Needs BuildKit and the containerd image store of the docker daemon")
+	pflag.StringVar(&ops.Provenance, "provenance", "", "Attach a provenance attestation to each image, with \"min\" or \"max\" detail. Implies OCI media types. Needs BuildKit and the containerd image store of the docker daemon. Without a value, it's \"min\"")
+	pflag.Lookup("provenance").NoOptDefVal = docker.ProvenanceMin
+	pflag.IntVar(&ops.ContextCompress, "context-compression", 0, "Gzip level the build context is sent to the docker daemon with, from 1 (fastest) to 9 (smallest); 0 disables compression. Speeds up builds on remote daemons behind slow networks")
+	pflag.BoolVar(&ops.ContextExcludeVCS, "context-exclude-vcs", false, "Leave version control directories, like .git, out of the build context")
+	pflag.StringVar(&ops.Platform, "platform", "", "Build images for the given platforms; e.g. \"linux/arm64\" or \"linux/amd64,linux/arm64\". Images of several platforms are pushed as one manifest list. Defaults to the daemon's platform")
+	pflag.StringArrayVar(&ops.PlatformsFor, "platforms-for", nil, "Build a range of versions for other platforms; e.g. \">=12:linux/amd64,linux/arm64\". Can be repeated; the first match wins, --platform is the fallback")
+	pflag.StringVar(&ops.BuildBackend, "build-backend", string(docker.BuildBackendAuto), "The builder of the docker daemon to build with: \"buildkit\", \"classic\" or \"auto\" (BuildKit if the daemon reports it as its builder, classic otherwise; builds BuildKit fails to start are retried with the classic builder)")
+	pflag.StringVar(&ops.BaseStrategy, "base-strategy", string(docker.BaseStrategyHistory), "How to detect the base image of built images: \"history\" (oldest locally known history entry), \"from-label\" (image referenced by the org.opencontainers.image.base.name label) or \"inspect-digest\" (local image matching the org.opencontainers.image.base.digest label)")
+	pflag.BoolVar(&ops.Lint, "lint", false, "Lint each rendered Dockerfile with the embedded rules and, if installed, hadolint")
+	pflag.StringSliceVar(&ops.LintRules, "lint-rules", lintRuleIDs(), "The embedded lint rules to apply")
+	pflag.BoolVar(&ops.StrictLint, "strict-lint", false, "Fail a version if linting its Dockerfile reports any finding")
+	pflag.StringArrayVar(&ops.AssertLabelPairs, "assert-label", nil, "Fail a version if its built image lacks the given label; e.g. \"org.opencontainers.image.vendor=ACME\". Can be repeated")
+	pflag.StringVar(&ops.SortOrder, "sort", sortAsc, "The order versions are built in: \"asc\" or \"desc\" for newest first. The latest tag always goes to the highest version")
+	pflag.StringVar(&ops.OnImmutable, "on-immutable", immutableFail, "What to do if the registry refuses to overwrite an existing tag: \"fail\" the version, \"skip\" it, or \"force\" the push of its remaining tags")
+	pflag.StringVar(&ops.StagingSuffix, "staging-suffix", "", "Push each image as <version><suffix> first and promote it to its final tags only once that push succeeded (and was verified); e.g. \"-staging\". Staging tags are not deleted from the registry")
+	pflag.BoolVar(&ops.VerifyPush, "verify-push", false, "After pushing, check that every pushed tag resolves to the pushed digest in the registry")
+	pflag.StringArrayVar(&ops.AssertEnvPairs, "assert-env", nil, "Fail a version if its built image lacks the given environment variable; e.g. \"LANG=de_DE.utf8\". Can be repeated")
+	pflag.BoolVar(&ops.StrictSemver, "strict-semver", false, "Only accept tags that are full X.Y.Z semantic versions and warn about tags that normalize to the same version")
+	pflag.BoolVar(&ops.Strict, "strict", false, "Turn sanity check warnings, like a FROM tag not matching the built version, into errors")
+	pflag.DurationVar(&ops.Timeout, "timeout", 0, "Abort the whole run after the given duration; e.g. \"2h\". Zero means no timeout")
+	pflag.BoolVar(&ops.Force, "force", false, "Rebuild all versions, even those completed by a previous, interrupted run. Takes precedence over --only-missing")
+	pflag.BoolVar(&ops.OnlyMissing, "only-missing", false, "Only build versions whose image exists neither in the target repository nor locally")
+	pflag.StringVar(&ops.AuthConfig, "auth-config", "", "Path to a docker config.json-style auth config to log in with; defaults to $DOCKER_AUTH_CONFIG, then $DOCKER_USERNAME and $DOCKER_PASSWORD")
+	pflag.BoolVar(&ops.InMemoryContext, "in-memory-context", false, "Render the templates into an in-memory build context instead of the build directory; meant for small contexts that consist of rendered templates only. Lint checks that need hadolint are skipped")
+	pflag.BoolVar(&ops.FromBuildDir, "from-build-dir", false, "Build the version directories already rendered into the build directory, e.g. by --emit-commands or --keep, instead of rendering templates. The versions are taken from the directory names; implies --keep")
+	pflag.BoolVar(&ops.EmitCommands, "emit-commands", false, "Render the build directories and print the equivalent docker build and push commands instead of executing them; implies --keep")
 	pflag.BoolVar(&ops.DryRun, "dry-run", false, "Enable dry run mode; build but don't push")
+	pflag.StringVar(&ops.ValidateCmd, "validate-cmd", "", "Shell command run against each rendered Dockerfile before building; a template receiving .Version and .Path, e.g. \"conftest test {{ .Path }}\". A non-zero exit fails the version")
+	pflag.StringVar(&ops.SmokeTest, "smoke-test", "", "Shell command run in a container of each built image before pushing it; e.g. \"postgres --version\". It's run with /bin/sh -c in place of the image's entrypoint, and a non-zero exit fails the version. Skipped in dry run mode")
+	pflag.DurationVar(&ops.SmokeTestTimeout, "smoke-test-timeout", defaultSmokeTestTimeout, "Fail the smoke test if it doesn't finish within the given duration; zero means no timeout")
+	pflag.StringVar(&ops.PostPushCmd, "post-push-cmd", "", "Shell command run after each pushed version; a template receiving .Version, .Tag, .Tags and .Digest, e.g. \"deploy.sh {{ .Tag }}\"")
+	pflag.BoolVar(&ops.FailOnHookError, "fail-on-hook-error", false, "Fail the version if the --post-push-cmd fails, instead of logging a warning")
+	pflag.BoolVar(&ops.NoFailFast, "no-fail-fast", false, "Continue with the remaining versions if a version fails and report all failures at the end. Interrupting the run still stops it immediately")
+	pflag.BoolVar(&ops.SummaryOnChange, "summary-only-on-change", false, "If no version was built or pushed, only log warnings and errors, and print a single \"no changes\" line instead of the run summary. Logs are held back until the end of the run")
+	pflag.BoolVar(&ops.FailOnEmptyPush, "fail-on-empty-push", false, "Fail with exit code 1 instead of 3 if no image was pushed; ignored in dry run mode")
+	pflag.StringVar(&ops.DockerHost, "docker-host", "", "Address of the docker daemon; e.g. tcp://builder:2376. Overrides DOCKER_HOST")
+	pflag.Float64Var(&ops.RegistryRPS, "registry-rps", docker.DefaultRegistryRPS, "Maximum number of registry API requests per second; 0 disables the limit")
+	pflag.BoolVar(&ops.RefreshTags, "refresh-tags", false, "Ignore the tag cache for this run and fetch the tags from the registry; the cache is overwritten with the fresh tags")
+	pflag.BoolVar(&ops.VerifyBase, "verify-base", false, "Verify the cosign signature of the source image of each version before building on it; the version fails if the image is unsigned or untrusted. Requires cosign and either --verify-base-key or --verify-base-identity and --verify-base-issuer")
+	pflag.StringVar(&ops.VerifyBaseKey, "verify-base-key", "", "Public key to verify the source image signatures with; a path or any key reference cosign supports")
+	pflag.StringVar(&ops.VerifyIdentity, "verify-base-identity", "", "Regular expression the signing certificate identity must match for keyless verification of the source images")
+	pflag.StringVar(&ops.VerifyIssuer, "verify-base-issuer", "", "OIDC issuer of the signing certificate for keyless verification of the source images; e.g. https://token.actions.githubusercontent.com")
+	pflag.StringVar(&ops.Dockerfile, "dockerfile", "", "Use the given file as the only template, rendered as Dockerfile for all versions, instead of a template directory; \"-\" reads it from stdin")
+	pflag.BoolVar(&ops.StrictTags, "strict-tags", false, "Fail if any source tag that looks like a version can't be parsed as one, instead of ignoring it and reporting it in the summary")
+	pflag.BoolVar(&ops.SkipUnchanged, "skip-unchanged", false, "Skip versions whose build inputs, i.e. source image, rendered build context, build args and tags, are unchanged since their last pushed build, as long as the tag still points at that build's digest")
+	pflag.BoolVar(&ops.Explain, "explain", false, "Print for each source tag whether it's selected for the run and, if not, why; then exit without building")
+	pflag.BoolVar(&ops.RollingMajor, "rolling-major", false, "Additionally tag the newest version of each major version with the major tag, e.g. 16.4 as 16, moving the tag on every run. Only versions selected for the run are considered")
+	pflag.BoolVar(&ops.RollingMinor, "rolling-minor", false, "Additionally tag the newest patch version of each minor version with the major.minor tag, e.g. 16.1.3 as 16.1, moving the tag on every run. Only versions selected for the run are considered")
+	pflag.StringVar(&ops.RecordTags, "record-tags", "", "Save the raw registry API responses of the tag discovery to the given file, to replay them with --replay-tags later; implies --refresh-tags. The file may contain anonymous registry tokens")
+	pflag.StringVar(&ops.ReplayTags, "replay-tags", "", "Serve the tag discovery from registry API responses saved with --record-tags instead of the network; implies --no-cache-file")
+	pflag.BoolVar(&ops.NoCacheFile, "no-cache-file", false, "Neither read nor write the tag cache file; tags are always fetched and only kept in memory")
+	pflag.BoolVar(&ops.ResumableFetch, "resumable-tag-fetch", false, "Save the progress of the tag fetch to the tag cache after every page, so an interrupted fetch continues where it stopped on the next run")
+	pflag.IntVar(&ops.MaxTagPages, "max-tag-pages", docker.DefaultMaxTagPages, "Maximum number of registry API pages fetched per repository during tag discovery; 0 disables the limit")
+	pflag.StringVar(&ops.SlackWebhook, "slack-webhook", "", "Slack incoming webhook URL to post a summary to once the run completes, successful or not. Defaults to the "+envSlackWebhook+" environment variable")
+	pflag.IntVar(&ops.Reconnect, "reconnect", 0, "Reconnect to the docker daemon and retry a build or push up to this many times if the connection drops midway; useful for remote daemons. Without a value, it's retried 3 times")
+	pflag.Lookup("reconnect").NoOptDefVal = "3"
+	pflag.StringVar(&ops.Proxy, "proxy", "", "Proxy URL for registry API requests (tag discovery); overrides HTTP_PROXY, HTTPS_PROXY and NO_PROXY. Pulls and pushes use the docker daemon's proxy settings")
+	pflag.StringVar(&ops.UserAgent, "user-agent", defaultUserAgent(), "The User-Agent header sent with registry API requests")
 	pflag.BoolVar(&ops.Debug, "debug", false, "Enable debug mode")
 	pflag.BoolVar(&ops.KeepBuildDirs, "keep", false, "Keep build directories after build")
+	pflag.BoolVar(&ops.TempBuild, "temp-build", false, "Render into a fresh temporary build directory that's removed after the run, regardless of --keep, instead of --build")
+	pflag.IntVar(&ops.RemoveConcurrency, "remove-concurrency", docker.DefaultRemoveConcurrency, "Maximum number of images removed concurrently")
+	pflag.IntVar(&ops.KeepImages, "keep-images", 1, "Number of most recently built images (and their base images) to keep locally")
+	pflag.BoolVar(&ops.KeepBaseImages, "keep-base-images", false, "Never remove base images, only built ones; avoids pulling shared base images again at the cost of disk space")
+	pflag.StringArrayVar(&ops.LoadArchives, "load", nil, "Load images from the given tar archive before building; can be repeated")
+	pflag.StringVar(&ops.TemplateGit, "template-git", "", "Clone the templates from a git repository instead of SOURCE-FILE; e.g. \"https://github.com/me/templates@v1\". The optional REF is a branch, tag or commit")
+	pflag.StringVar(&ops.PostBuildSnippet, "post-build-dockerfile", "", "Path to a Dockerfile snippet (e.g. LABEL or HEALTHCHECK instructions) applied on top of each built image")
+	pflag.StringVar(&ops.ReportFormat, "report", "", "Write a report of the run in the given format; currently only \"html\" is supported")
+	pflag.StringVar(&ops.ReportFile, "report-file", "mimikry-report.html", "The path of the report written by --report")
+	pflag.BoolVar(&ops.StepTimings, "step-timings", false, "Log how long each build step took and include the breakdown in the report")
+	pflag.BoolVar(&ops.CompareBases, "compare-base-digests", false, "After building, report which versions share the same base image")
+	pflag.StringVar(&ops.DigestFile, "digest-file", "", "Write a JSON object mapping every pushed tag to its digest to the given path; e.g. for pinning deployments. Also written if the run fails")
+	pflag.StringVar(&ops.ChangelogFile, "changelog-file", "", "Write a markdown changelog of the versions added or rebuilt since the previous runs to the given path")
+	pflag.StringVar(&ops.ExportDir, "export-dir", "", "Save each built image as <version>.tar into the given directory")
+	pflag.BoolVar(&ops.PrintVersion, "print-version", false, "Print the version, commit and build date of mimikry and exit")
 
 	pflag.Usage = printHelp
+
 	pflag.Parse()
 
-	// Source file and target repo are required
-	if pflag.NArg() != 2 {
-		return nil, errors.New("missing arguments; see usage (-h) for more information")
+	// Printing the version doesn't need any arguments
+	if ops.PrintVersion {
+		return &ops, nil
 	}
 
-	// Set values from CLI args
-	ops.TemplatePath = pflag.Arg(0)
-	ops.TargetRepo = pflag.Arg(1)
-
-	// Clean up some paths
-	ops.TemplatePath = cleanPath(ops.TemplatePath)
-	ops.BuildDir = cleanPath(ops.BuildDir)
+	// Source file and target repo are required; the source file is omitted when templates come from git or a single
+	// Dockerfile, or aren't rendered at all
+	args := pflag.Args()
+	numArgs := 2
+	if ops.TemplateGit != "" || ops.FromBuildDir || ops.Dockerfile != "" {
+		numArgs = 1
+	}
 
-	return &ops, nil
-}
+	// "build" may lead the arguments, for symmetry with the subcommands
+	if len(args) == numArgs+1 && args[0] == "build" {
+		args = args[1:]
+	}
 
-func getTagBuildDir(baseDir, version string) string {
-	return filepath.FromSlash(filepath.Join(baseDir, version))
-}
+	if len(args) != numArgs {
+		return nil, errors.New("missing arguments; see usage (-h) for more information")
+	}
 
-func prepareBuildDirectory(path string, version *semver.Version, templates *template.Template, opts *options) error {
-	// Create directory for version if it doesn't exist
-	if err := os.MkdirAll(path, 0o750); err != nil {
-		return fmt.Errorf("create build directory: %w", err)
+	// Set values from CLI args
+	ops.TargetRepo = args[numArgs-1]
+	if numArgs == 2 {
+		ops.TemplatePath = cleanPath(args[0])
 	}
 
-	eg := &errgroup.Group{}
+	// The first maintainer is the maintainer
+	ops.Maintainer = ops.Maintainers[0]
 
-	for _, rawTemplate := range templates.Templates() {
-		rawTemplate := rawTemplate
-		eg.Go(func() error {
-			// Open Dockerfile for version
-			outputPath := filepath.Join(path, rawTemplate.Name())
-			outputFile, err := os.Create(outputPath)
-			if err != nil {
-				return fmt.Errorf("create template %q: %w", rawTemplate.Name(), err)
-			}
-			defer outputFile.Close()
+	// Load template variables
+	var err error
+	ops.TemplateVars, err = mergeTemplateVars(ops.VarsFile, ops.Vars)
+	if err != nil {
+		return nil, err
+	}
 
-			// TODO: Remove specific use-case
-			installTools := !version.LessThan(semver.MustParse("10.0.0"))
+	ops.BuildArgs, err = loadBuildArgs(ops.BuildArgFile, ops.BuildArgPairs)
+	if err != nil {
+		return nil, err
+	}
 
-			// Execute template
-			data := templateData{
-				Version:      version.Original(),
-				Maintainer:   opts.Maintainer,
-				InstallTools: installTools,
-				Tools:        defaultDockerTools, // TODO: Make this configurable
-			}
+	if ops.VersionBuildArg != "" {
+		if strings.ContainsAny(ops.VersionBuildArg, "= ") {
+			return nil, fmt.Errorf("invalid --version-build-arg %q; expected a build arg name", ops.VersionBuildArg)
+		}
 
-			if err = rawTemplate.Execute(outputFile, data); err != nil {
-				return fmt.Errorf("execute template %q: %w", rawTemplate.Name(), err)
-			}
+		if _, ok := ops.BuildArgs[ops.VersionBuildArg]; ok {
+			return nil, fmt.Errorf("build arg %s is set by both --version-build-arg and --build-arg", ops.VersionBuildArg)
+		}
+	}
 
-			return nil
-		})
+	if ops.PostPushCmd != "" {
+		ops.PostPushHook, err = parseCommandHook("post-push-cmd", ops.PostPushCmd)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return eg.Wait()
-}
+	if ops.ValidateCmd != "" {
+		if ops.InMemoryContext {
+			return nil, errors.New("--validate-cmd can't be combined with --in-memory-context")
+		}
 
-func cleanupBuildDirs(ctx context.Context, dirs []string) {
-	logger := simplog.FromContext(ctx)
+		ops.ValidateHook, err = parseCommandHook("validate-cmd", ops.ValidateCmd)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	for _, dir := range dirs {
-		dir = filepath.FromSlash(dir)
+	if ops.Platform != "" {
+		if ops.Platforms, err = parsePlatforms(ops.Platform); err != nil {
+			return nil, err
+		}
+	}
 
-		logger.Debugf("Removing build directory %s", dir)
-		if err := os.RemoveAll(dir); err != nil {
-			logger.Errorf("Failed to remove build directory %s: %v", dir, err)
+	ops.PlatformMappings, err = parseVersionMappings(ops.PlatformsFor)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mapping := range ops.PlatformMappings {
+		if _, err = parsePlatforms(mapping.Value); err != nil {
+			return nil, err
 		}
 	}
-}
 
-func main() {
-	// Create signal cancel context
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
-	defer cancel()
+	ops.ExtraEnv, err = parseKeyValues(ops.ExtraEnvPairs)
+	if err != nil {
+		return nil, err
+	}
 
-	// Get options from CLI
-	opts, err := optionsFromCLI()
+	if ops.ContextCompress < 0 || ops.ContextCompress > 9 {
+		return nil, fmt.Errorf("invalid --context-compression %d; expected 0 to 9", ops.ContextCompress)
+	}
+
+	ops.BuildResources, err = parseBuildResources(ops.BuildMemory, ops.BuildCPUs, ops.BuildUlimitSpecs)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, err
 	}
 
-	// Setup logger
-	logger := simplog.NewClientLogger(opts.Debug)
-	ctx = simplog.WithLogger(ctx, logger)
+	ops.Secrets, err = parseBuildSecrets(ops.SecretSpecs)
+	if err != nil {
+		return nil, err
+	}
 
-	// Parse all template files in the template directory
-	templates, err := template.ParseGlob(filepath.Join(opts.TemplatePath, "*"))
+	ops.SSH, err = parseBuildSSH(ops.SSHSpecs)
 	if err != nil {
-		logger.Error(err)
-		os.Exit(1)
+		return nil, err
 	}
 
-	// Run main
-	if err = realMain(ctx, templates, opts); err != nil && !errors.Is(err, context.Canceled) {
-		logger.Error(err)
-		os.Exit(1)
+	if ops.Provenance != "" && ops.Provenance != docker.ProvenanceMin && ops.Provenance != docker.ProvenanceMax {
+		return nil, fmt.Errorf("invalid --provenance %q; expected %q or %q", ops.Provenance, docker.ProvenanceMin, docker.ProvenanceMax)
 	}
-}
 
-func realMain(ctx context.Context, templates *template.Template, opts *options) error {
-	logger := simplog.FromContext(ctx)
+	if flags := buildKitFlags(&ops); len(flags) > 0 && ops.BuildBackend == string(docker.BuildBackendClassic) {
+		return nil, fmt.Errorf("BuildKit is required by %s; it can't be used with --build-backend classic", strings.Join(flags, ", "))
+	}
 
-	// Parse the versions constraint
-	versionConstraint, err := semver.NewConstraint(opts.VersionConstraint)
+	ops.AssertLabels, err = parseKeyValues(ops.AssertLabelPairs)
 	if err != nil {
-		return fmt.Errorf("parse version constraint: %w", err)
+		return nil, err
 	}
-	logger.Debugf("Parsed version constraint: %s", versionConstraint)
 
-	// Create docker client
-	logger.Debug("Creating docker client")
-	client, err := docker.New(ctx)
+	ops.AssertEnv, err = parseKeyValues(ops.AssertEnvPairs)
 	if err != nil {
-		return fmt.Errorf("create docker client: %w", err)
+		return nil, err
 	}
-	defer func() { _ = client.Close(ctx) }()
 
-	// Login
-	if !opts.DryRun {
-		logger.Info("Logging in to docker")
-		if err = client.LoginFromEnv(ctx); err != nil {
-			return fmt.Errorf("login to docker: %w", err)
-		}
-		defer func() { _ = client.Logout(ctx) }()
-	} else {
-		logger.Info("Dry run enabled; skipping authentication")
+	ops.TemplateMappings, err = parseVersionMappings(ops.TemplatesFor)
+	if err != nil {
+		return nil, err
 	}
 
-	// Try to load tags from cache
-	logger.Info("Loading image tags")
-	logger.Debug("Trying to load tag cache")
-
-	tags, err := loadTagCache(postgresCachePath)
+	ops.ToolMappings, err = parseVersionMappings(ops.ToolsFor)
 	if err != nil {
-		logger.Debugf("Failed to load tag cache: %v", err)
+		return nil, err
 	}
 
-	if tags != nil {
-		logger.Debug("Using tag cache")
-	} else {
-		logger.Debug("No tag cache found; loading remote tags")
-		tagList, err := docker.GetDockerHubRepoTags(ctx, defaultSourceRepo)
-		if err != nil {
-			return fmt.Errorf("load remote tags: %w", err)
+	if len(ops.Versions) > 0 {
+		if pflag.CommandLine.Changed("version") || ops.Preset != "" {
+			return nil, errors.New("--versions, --version and --preset are mutually exclusive")
 		}
 
-		// Create tag cache
-		tags = &imageTags{
-			Image:    defaultSourceRepo,
-			Modified: time.Now(),
-			Tags:     tagList,
+		for idx, rawVersion := range ops.Versions {
+			ops.Versions[idx] = strings.TrimSpace(rawVersion)
+			if _, err = semver.NewVersion(ops.Versions[idx]); err != nil {
+				return nil, fmt.Errorf("parse version %q of --versions: %w", rawVersion, err)
+			}
 		}
 	}
 
-	numTags := len(tags.Tags)
-	logger.Debugf("Loaded %d tags", numTags)
+	if ops.Preset != "" {
+		if pflag.CommandLine.Changed("version") {
+			return nil, errors.New("--preset and --version are mutually exclusive")
+		}
 
-	// Pre-sort and -filter tags; this does worsen the performance technically, but it avoids a lot
-	// of issues down the line.
-	versions := make([]*semver.Version, 0, numTags)
-	for _, tag := range tags.Tags {
-		// Sanitize tag and skip if it's not a major.minor version
-		tag = strings.TrimSpace(tag)
-		if stdSkipTagFunc(tag) {
-			// Not removing the tag from the list as it might be requested by the user later
-			logger.Debugf("Skipping version %s; not a major.minor version", tag)
-			continue
+		if !slices.Contains(versionPresets, ops.Preset) {
+			return nil, fmt.Errorf("unknown version preset %q", ops.Preset)
 		}
+	}
 
-		version, err := semver.NewVersion(tag)
-		if err != nil {
-			logger.Warnf("Failed to parse tag %s: %v", tag, err)
-			continue
+	if ops.FromBuildDir && (ops.TemplateGit != "" || ops.EmitCommands) {
+		return nil, errors.New("--from-build-dir can't be combined with --template-git or --emit-commands")
+	}
+
+	if ops.Dockerfile != "" && (ops.TemplateGit != "" || ops.FromBuildDir) {
+		return nil, errors.New("--dockerfile can't be combined with --template-git or --from-build-dir")
+	}
+
+	if ops.SmokeTestTimeout < 0 {
+		return nil, errors.New("--smoke-test-timeout must not be negative")
+	}
+
+	if ops.Reconnect < 0 {
+		return nil, errors.New("--reconnect must not be negative")
+	}
+
+	if ops.VerifyBase {
+		if ops.VerifyBaseKey == "" && (ops.VerifyIdentity == "" || ops.VerifyIssuer == "") {
+			return nil, errors.New("--verify-base requires --verify-base-key or both --verify-base-identity and --verify-base-issuer")
 		}
 
-		// Check if the version matches the constraint
-		if !versionConstraint.Check(version) {
-			logger.Debugf("Skipping version %s; does not match constraint", tag)
-			continue
+		if ops.VerifyBaseKey != "" && (ops.VerifyIdentity != "" || ops.VerifyIssuer != "") {
+			return nil, errors.New("--verify-base-key can't be combined with --verify-base-identity or --verify-base-issuer")
 		}
+	}
 
-		// Finally, add the version to the list
-		logger.Debugf("Adding version %s", tag)
-		versions = append(versions, version)
+	if ops.NoCacheFile && ops.ResumableFetch {
+		return nil, errors.New("--no-cache-file can't be combined with --resumable-tag-fetch")
 	}
 
-	sort.Sort(semver.Collection(versions))
-	numTags = len(versions)
-	logger.Debugf("%d tags after sorting and filtering", numTags)
+	if ops.RecordTags != "" && ops.ReplayTags != "" {
+		return nil, errors.New("--record-tags and --replay-tags are mutually exclusive")
+	}
 
-	// Build directory tree and generate Dockerfile from template for each version
-	logger.Info("Building and uploading images")
+	// Recording needs the tags to actually be fetched, and replayed tags must not end up in the tag cache
+	if ops.RecordTags != "" {
+		ops.RefreshTags = true
+	}
 
-	// Persist tags to cache file and cleanup build directories
-	var pathsToCleanup []string
-	defer func() {
-		// Save tag cache; it's deferred as the main loop might alter the tags
-		logger.Debug("Saving tag cache")
-		if err = saveTagCache(postgresCachePath, tags); err != nil {
-			logger.Errorf("Failed to save tag cache: %v", err)
+	if ops.ReplayTags != "" {
+		if ops.ResumableFetch {
+			return nil, errors.New("--replay-tags can't be combined with --resumable-tag-fetch")
 		}
+		ops.NoCacheFile = true
+	}
 
-		// Cleanup build directories
-		cleanupBuildDirs(ctx, pathsToCleanup)
-	}()
+	if ops.SlackWebhook == "" {
+		ops.SlackWebhook = os.Getenv(envSlackWebhook)
+	}
 
-	// Build and push all images
-	previousImage := ""
-	previousBaseImage := ""
-	for idx, version := range versions {
-		if ctx.Err() != nil {
-			return ctx.Err()
+	if !pflag.CommandLine.Changed("source-date-epoch") {
+		if rawEpoch := os.Getenv(docker.BuildArgSourceDateEpoch); rawEpoch != "" {
+			if ops.SourceDateEpoch, err = strconv.ParseInt(rawEpoch, 10, 64); err != nil {
+				return nil, fmt.Errorf("parse SOURCE_DATE_EPOCH: %w", err)
+			}
 		}
+	}
 
-		logger.Debugf("Processing tag %d/%d: %s", idx+1, numTags, version)
+	if ops.SourceDateEpoch < 0 {
+		return nil, errors.New("--source-date-epoch must not be negative")
+	}
 
-		// Create build directory
-		buildDirectory := getTagBuildDir(opts.BuildDir, version.Original())
-		if err = prepareBuildDirectory(buildDirectory, version, templates, opts); err != nil {
-			return fmt.Errorf("create version directory: %w", err)
+	if ops.SourceDateEpoch > 0 {
+		if ops.CreatedFromSource {
+			return nil, errors.New("--source-date-epoch and --created-from-source are mutually exclusive")
 		}
 
-		// If the user does not want to keep the build directories, add them to the cleanup list
-		if !opts.KeepBuildDirs {
-			pathsToCleanup = append(pathsToCleanup, buildDirectory)
+		ops.SourceDate = time.Unix(ops.SourceDateEpoch, 0).UTC()
+	}
+
+	if ops.TempBuild && (pflag.CommandLine.Changed("build") || ops.FromBuildDir || ops.EmitCommands) {
+		return nil, errors.New("--temp-build can't be combined with --build, --from-build-dir or --emit-commands")
+	}
+
+	if ops.InMemoryContext && (ops.FromBuildDir || ops.EmitCommands || ops.KeepBuildDirs) {
+		return nil, errors.New("--in-memory-context can't be combined with --from-build-dir, --emit-commands or --keep")
+	}
+
+	if ops.SortOrder != sortAsc && ops.SortOrder != sortDesc {
+		return nil, fmt.Errorf("unknown sort order %q; expected %q or %q", ops.SortOrder, sortAsc, sortDesc)
+	}
+
+	if !slices.Contains(immutablePolicies, ops.OnImmutable) {
+		return nil, fmt.Errorf("unknown --on-immutable policy %q", ops.OnImmutable)
+	}
+
+	if !slices.Contains(docker.BaseStrategies, docker.BaseStrategy(ops.BaseStrategy)) {
+		return nil, fmt.Errorf("unknown base detection strategy %q", ops.BaseStrategy)
+	}
+
+	if !slices.Contains(docker.BuildBackends, docker.BuildBackend(ops.BuildBackend)) {
+		return nil, fmt.Errorf("unknown build backend %q", ops.BuildBackend)
+	}
+
+	if ops.ReportFormat != "" && ops.ReportFormat != reportFormatHTML {
+		return nil, fmt.Errorf("unsupported report format %q", ops.ReportFormat)
+	}
+
+	if ops.Source != "" {
+		if pflag.CommandLine.Changed("source-repo") {
+			return nil, errors.New("--source and --source-repo are mutually exclusive")
 		}
+		ops.SourceRepos = []string{normalizeSourceRepo(ops.Source)}
+	}
+
+	if len(ops.SourceRepos) == 0 {
+		return nil, errors.New("at least one source repository is required")
+	}
+	ops.DetectSourceRepo = !pflag.CommandLine.Changed("source-repo") && ops.Source == ""
+
+	if strings.TrimSpace(ops.LatestTag) == "" {
+		return nil, errors.New("--latest-tag must not be empty")
+	}
 
-		// If this is the last image, tag it as latest
-		imageTag := fmt.Sprintf("%s:%s", opts.TargetRepo, version.Original())
-		tags := []string{imageTag}
-		if opts.TagLatest && idx == numTags-1 {
-			tags = append(tags, fmt.Sprintf("%s:%s", opts.TargetRepo, "latest"))
-			logger.Infof("Tagging image %s as latest", imageTag)
+	if ops.DockerHost != "" {
+		if err := docker.ValidateHost(ops.DockerHost); err != nil {
+			return nil, err
 		}
+	}
 
-		// Build image
-		buildDirectory = filepath.Join(defaultBuildDirectory, version.Original())
+	if ops.RemoveConcurrency < 1 {
+		return nil, errors.New("--remove-concurrency must be at least 1")
+	}
 
-		logger.Infof("Building image %s", imageTag)
-		imageID, baseID, err := client.Images().Build(ctx, buildDirectory, tags...)
-		if err != nil {
-			return fmt.Errorf("build image: %w", err)
+	if ops.KeepImages < 0 {
+		return nil, errors.New("--keep-images must not be negative")
+	}
+
+	// Clean up some paths
+	ops.BuildDir = cleanPath(ops.BuildDir)
+	if ops.ExportDir != "" {
+		ops.ExportDir = cleanPath(ops.ExportDir)
+	}
+	if ops.PostBuildSnippet != "" {
+		ops.PostBuildSnippet = cleanPath(ops.PostBuildSnippet)
+	}
+	if ops.Dockerignore != "" {
+		ops.Dockerignore = cleanPath(ops.Dockerignore)
+		if _, err := os.Stat(ops.Dockerignore); err != nil {
+			return nil, fmt.Errorf("check dockerignore file: %w", err)
 		}
+	}
+	ops.ReportFile = cleanPath(ops.ReportFile)
+	if ops.ChangelogFile != "" {
+		ops.ChangelogFile = cleanPath(ops.ChangelogFile)
+	}
+	if ops.DigestFile != "" {
+		ops.DigestFile = cleanPath(ops.DigestFile)
+	}
+	if ops.AuthConfig != "" {
+		ops.AuthConfig = cleanPath(ops.AuthConfig)
+	}
+
+	return &ops, nil
+}
+
+// parseTemplates parses all regular files in the given directory as templates. Directories, like the .git directory of
+// a cloned template repository, are skipped, except for the partials directory. Its files are parsed as partials named
+// "partials/<file>".
+func parseTemplates(dir string) (*template.Template, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read template directory: %w", err)
+	}
 
-		if imageID == "" || baseID == "" {
-			return fmt.Errorf("build image: %w", errors.New("image id or base id is empty"))
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			files = append(files, filepath.Join(dir, entry.Name()))
 		}
+	}
 
-		logger.Debugf("Image %s built based on parent image %s", imageID, baseID)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no templates found in %s", dir)
+	}
 
-		// Push image
-		if !opts.DryRun {
-			logger.Infof("Pushing image %s", imageTag)
-			err = client.Images().Push(ctx, tags...)
-			if err != nil {
-				return fmt.Errorf("push image: %w", err)
-			}
-		} else {
-			logger.Infof("Dry run enabled; skipping push for image %s", imageTag)
+	templates, err := template.ParseFiles(files...)
+	if err != nil {
+		return nil, err
+	}
+
+	partials, err := os.ReadDir(filepath.Join(dir, partialsDirectory))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return templates, nil
 		}
 
-		// Clean-up
+		return nil, fmt.Errorf("read partials directory: %w", err)
+	}
 
-		// Remove images
-		imagesToRemove := make([]string, 0, 2)
+	for _, entry := range partials {
+		if entry.IsDir() {
+			continue
+		}
 
-		if previousImage != "" {
-			imagesToRemove = append(imagesToRemove, previousImage)
+		content, err := os.ReadFile(filepath.Join(dir, partialsDirectory, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read partial: %w", err)
 		}
 
-		if previousBaseImage != "" {
-			imagesToRemove = append(imagesToRemove, previousBaseImage)
+		if _, err = templates.New(partialsDirectory + "/" + entry.Name()).Parse(string(content)); err != nil {
+			return nil, fmt.Errorf("parse partial: %w", err)
 		}
+	}
 
-		if len(imagesToRemove) > 0 {
-			logger.Infof("Removing build artifacts")
-			if err = client.Images().Remove(ctx, imagesToRemove...); err != nil {
-				return fmt.Errorf("remove images: %w", err)
-			}
+	return templates, nil
+}
+
+// readDockerfileTemplate reads the single Dockerfile template from the given path or, if the path is "-", from stdin.
+func readDockerfileTemplate(path string) ([]byte, error) {
+	if path == "-" {
+		content, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("read Dockerfile template from stdin: %w", err)
 		}
 
-		previousImage = imageID
-		previousBaseImage = baseID
+		return content, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read Dockerfile template: %w", err)
+	}
+
+	return content, nil
+}
+
+// isRenderedTemplate reports whether the given template gets rendered into the build directory. Partials, i.e. files
+// starting with an underscore or located in the partials directory, and templates declared through {{ define }} are
+// only available to other templates via {{ template "name" . }}.
+func isRenderedTemplate(tmpl *template.Template) bool {
+	name := tmpl.Name()
+	if strings.HasPrefix(name, "_") || strings.HasPrefix(name, partialsDirectory+"/") {
+		return false
+	}
+
+	// Declared templates carry the name of the file they were declared in as parse name
+	return tmpl.Tree != nil && tmpl.Tree.ParseName == name
+}
+
+// imageTagsFor returns the tags of the image built for the given version. The version's own tag comes first. If
+// latestTag is not empty, the image is tagged with it as well.
+func imageTagsFor(targetRepo, version string, aliases []string, latestTag string) []string {
+	tags := []string{fmt.Sprintf("%s:%s", targetRepo, version)}
+	for _, alias := range aliases {
+		tags = append(tags, fmt.Sprintf("%s:%s", targetRepo, alias))
+	}
+
+	if latestTag != "" {
+		tags = append(tags, fmt.Sprintf("%s:%s", targetRepo, latestTag))
+	}
+
+	return tags
+}
+
+// shellQuote joins the given command line, quoting arguments where needed so it can be pasted into a POSIX shell.
+func shellQuote(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`!*?[](){}<>|&;#~") {
+			quoted = append(quoted, arg)
+			continue
+		}
+
+		quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// emitCommands renders the build directory of each version and prints the docker commands that build and push it.
+func emitCommands(versions []*semver.Version, latestVersion string, templates *template.Template, opts *options) error {
+	buildOptions := newBuildOptions(opts)
+
+	rolling := rollingTags(versions, rollingGranularities(opts)...)
+
+	for _, version := range versions {
+		buildDirectory := getTagBuildDir(opts.BuildDir, version.Original())
+		if err := prepareBuildDirectory(buildDirectory, version, templates, opts); err != nil {
+			return fmt.Errorf("create version directory: %w", err)
+		}
+
+		latestTag := ""
+		if opts.TagLatest && version.Original() == latestVersion {
+			latestTag = opts.LatestTag
+		}
+
+		tags := imageTagsFor(opts.TargetRepo, version.Original(), rolling[version.Original()], latestTag)
+
+		versionBuildOptions := withVersionBuildArg(buildOptions, opts.VersionBuildArg, version.Original())
+
+		platforms := platformsFor(opts, version)
+		if len(platforms) <= 1 {
+			versionBuildOptions.Platform = strings.Join(platforms, ",")
+			fmt.Println(shellQuote(docker.BuildCommand(buildDirectory, versionBuildOptions, tags...)))
+			if !opts.DryRun {
+				if opts.StagingSuffix != "" {
+					stagingTag := tags[0] + opts.StagingSuffix
+					fmt.Println(shellQuote(docker.TagCommand(tags[0], stagingTag)))
+					fmt.Println(shellQuote(docker.PushCommand(stagingTag)))
+				}
+
+				for _, tag := range tags {
+					fmt.Println(shellQuote(docker.PushCommand(tag)))
+				}
+			}
+
+			continue
+		}
+
+		// Several platforms are built and pushed one by one, then combined into a manifest list per tag
+		platformTags := make([]string, 0, len(platforms))
+		for _, platform := range platforms {
+			versionBuildOptions.Platform = platform
+			platformTags = append(platformTags, platformTag(tags[0], platform))
+			fmt.Println(shellQuote(docker.BuildCommand(buildDirectory, versionBuildOptions, platformTags[len(platformTags)-1])))
+		}
+
+		if !opts.DryRun {
+			for _, tag := range platformTags {
+				fmt.Println(shellQuote(docker.PushCommand(tag)))
+			}
+
+			if opts.StagingSuffix != "" {
+				tags = append([]string{tags[0] + opts.StagingSuffix}, tags...)
+			}
+
+			for _, tag := range tags {
+				fmt.Println(shellQuote(docker.ManifestCreateCommand(tag, platformTags...)))
+				fmt.Println(shellQuote(docker.ManifestPushCommand(tag)))
+			}
+		}
+	}
+
+	return nil
+}
+
+const (
+	// labelCreated is the OCI annotation holding the creation time of an image.
+	labelCreated = "org.opencontainers.image.created"
+	// labelRevision is the OCI annotation holding the source control revision an image was built from.
+	labelRevision = "org.opencontainers.image.revision"
+)
+
+// createdLabels returns the labels that date the image of the given version to the time its source tag was last
+// pushed. It returns no labels if that time is unknown.
+func createdLabels(ctx context.Context, pushed map[string]time.Time, version string) map[string]string {
+	created, ok := pushed[version]
+	if !ok {
+		simplog.FromContext(ctx).Warnf("Push time of source tag %s unknown; using the build time as creation time", version)
+		return nil
+	}
+
+	return map[string]string{labelCreated: created.UTC().Format(time.RFC3339)}
+}
+
+// withVersionBuildArg returns a copy of the given build options with the build arg of the given name set to the version.
+// If name is empty, the options are returned as they are.
+func withVersionBuildArg(buildOptions docker.BuildOptions, name, version string) docker.BuildOptions {
+	if name == "" {
+		return buildOptions
+	}
+
+	buildArgs := make(map[string]string, len(buildOptions.BuildArgs)+1)
+	maps.Copy(buildArgs, buildOptions.BuildArgs)
+	buildArgs[name] = version
+	buildOptions.BuildArgs = buildArgs
+
+	return buildOptions
+}
+
+// parsePlatforms parses a comma-separated list of os/arch[/variant] platforms. Platforms may be listed only once.
+func parsePlatforms(spec string) ([]string, error) {
+	platforms := strings.Split(spec, ",")
+	for i, platform := range platforms {
+		platform = strings.TrimSpace(platform)
+		if parts := strings.Split(platform, "/"); len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid platform %q; expected os/arch[/variant]", platform)
+		}
+
+		if slices.Contains(platforms[:i], platform) {
+			return nil, fmt.Errorf("duplicate platform %q", platform)
+		}
+		platforms[i] = platform
+	}
+
+	return platforms, nil
+}
+
+// requestedPlatforms returns the distinct platforms given by --platform and --platforms-for.
+func requestedPlatforms(opts *options) []string {
+	var platforms []string
+	for _, platform := range opts.Platforms {
+		if !slices.Contains(platforms, platform) {
+			platforms = append(platforms, platform)
+		}
+	}
+
+	for _, mapping := range opts.PlatformMappings {
+		mapped, _ := parsePlatforms(mapping.Value)
+		for _, platform := range mapped {
+			if !slices.Contains(platforms, platform) {
+				platforms = append(platforms, platform)
+			}
+		}
+	}
+
+	return platforms
+}
+
+// platformsFor returns the platforms to build the given version for; those of the first matching --platforms-for
+// mapping, or --platform if none matches. No platforms means the daemon's platform.
+func platformsFor(opts *options, version *semver.Version) []string {
+	if spec, ok := matchVersionMapping(opts.PlatformMappings, version); ok {
+		platforms, _ := parsePlatforms(spec)
+		return platforms
+	}
+
+	return opts.Platforms
+}
+
+// platformTag returns the tag an image built for one of several platforms is pushed under, before it's referenced by
+// the manifest list of all platforms; e.g. "16.3-linux-arm64" for linux/arm64.
+func platformTag(tag, platform string) string {
+	return tag + "-" + strings.ReplaceAll(platform, "/", "-")
+}
+
+// newBuildOptions returns the build options shared by all versions.
+func newBuildOptions(opts *options) docker.BuildOptions {
+	buildOptions := docker.BuildOptions{
+		Platform:           opts.Platform,
+		BuildArgs:          opts.BuildArgs,
+		DockerignorePath:   opts.Dockerignore,
+		SourceDateEpoch:    opts.SourceDate,
+		Memory:             opts.BuildResources.Memory,
+		CPUs:               opts.BuildResources.CPUs,
+		Ulimits:            opts.BuildResources.Ulimits,
+		ContextCompression: opts.ContextCompress,
+		ContextExcludeVCS:  opts.ContextExcludeVCS,
+		Secrets:            opts.Secrets,
+		SSH:                opts.SSH,
+		OCIMediaTypes:      opts.OCI,
+		Provenance:         opts.Provenance,
+	}
+
+	labels := make(map[string]string)
+	if !opts.SourceDate.IsZero() {
+		labels[labelCreated] = opts.SourceDate.Format(time.RFC3339)
+	}
+
+	if opts.TemplateRevision != "" {
+		labels[labelRevision] = opts.TemplateRevision
+	}
+
+	if len(labels) > 0 {
+		buildOptions.Labels = labels
+	}
+
+	return buildOptions
+}
+
+// highestVersion returns the original form of the highest of the given versions, regardless of their order. It
+// returns an empty string if there are no versions.
+func highestVersion(versions []*semver.Version) string {
+	var highest *semver.Version
+	for _, version := range versions {
+		if highest == nil || version.GreaterThan(highest) {
+			highest = version
+		}
+	}
+
+	if highest == nil {
+		return ""
+	}
+
+	return highest.Original()
+}
+
+func getTagBuildDir(baseDir, version string) string {
+	return filepath.FromSlash(filepath.Join(baseDir, version))
+}
+
+// writeRenderedFile writes a rendered file into a build directory, atomically. If modTime is set, the file gets it as
+// modification time, so the build context doesn't depend on when it was rendered.
+func writeRenderedFile(path string, content []byte, modTime time.Time) error {
+	if err := writeFileAtomic(path, content, 0o644); err != nil {
+		return err
+	}
+
+	if modTime.IsZero() {
+		return nil
+	}
+
+	return os.Chtimes(path, modTime, modTime)
+}
+
+// renderContextFiles renders the templates for the given version into memory, keyed by their output file name, as
+// prepareBuildDirectory would write them into the build directory.
+func renderContextFiles(version *semver.Version, templates *template.Template, opts *options) (map[string][]byte, error) {
+	data := newTemplateData(version, opts)
+	selection := selectTemplates(templates, version, opts.TemplateMappings)
+
+	files := make(map[string][]byte, len(selection)+1)
+	if opts.DefaultIgnore && opts.Dockerignore == "" {
+		files[".dockerignore"] = defaultDockerignore
+	}
+
+	for _, selected := range selection {
+		var rendered bytes.Buffer
+		if err := selected.Template.Execute(&rendered, data); err != nil {
+			return nil, fmt.Errorf("execute template %q: %w", selected.Template.Name(), err)
+		}
+
+		files[filepath.ToSlash(selected.Output)] = rendered.Bytes()
+	}
+
+	return files, nil
+}
+
+// buildDirTags returns the names of the version directories in the given build directory as tags, e.g. for a build
+// directory rendered by a previous run. Other directories, like those of post-build images, are ignored.
+func buildDirTags(buildDir string) (*imageTags, error) {
+	entries, err := os.ReadDir(buildDir)
+	if err != nil {
+		return nil, fmt.Errorf("read build directory: %w", err)
+	}
+
+	tags := &imageTags{Image: buildDir}
+	for _, entry := range entries {
+		if entry.IsDir() && !stdSkipTagFunc(entry.Name()) {
+			tags.Tags = append(tags.Tags, entry.Name())
+		}
+	}
+
+	if len(tags.Tags) == 0 {
+		return nil, fmt.Errorf("no version directories found in %s", buildDir)
+	}
+
+	return tags, nil
+}
+
+// prepareBuildContext prepares the build context of the given version and returns the build directory it's built
+// from. The templates are rendered into the version's directory below the build directory or, with an in-memory build
+// context, into the returned files instead. Directories taken from a previous run are used as they are.
+func prepareBuildContext(version *semver.Version, templates *template.Template, opts *options) (string, map[string][]byte, error) {
+	buildDirectory := getTagBuildDir(opts.BuildDir, version.Original())
+	switch {
+	case opts.InMemoryContext:
+		contextFiles, err := renderContextFiles(version, templates, opts)
+		if err != nil {
+			return "", nil, fmt.Errorf("render build context: %w", err)
+		}
+
+		return buildDirectory, contextFiles, nil
+	case !opts.FromBuildDir:
+		if err := prepareBuildDirectory(buildDirectory, version, templates, opts); err != nil {
+			return "", nil, fmt.Errorf("create version directory: %w", err)
+		}
+	}
+
+	return buildDirectory, nil, nil
+}
+
+func prepareBuildDirectory(path string, version *semver.Version, templates *template.Template, opts *options) error {
+	// Create directory for version if it doesn't exist
+	if err := os.MkdirAll(path, 0o750); err != nil {
+		return fmt.Errorf("create build directory: %w", err)
+	}
+
+	data := newTemplateData(version, opts)
+	eg := &errgroup.Group{}
+
+	selection := selectTemplates(templates, version, opts.TemplateMappings)
+	if opts.DefaultIgnore && opts.Dockerignore == "" && !slices.ContainsFunc(selection, func(s selectedTemplate) bool { return s.Output == ".dockerignore" }) {
+		if err := writeRenderedFile(filepath.Join(path, ".dockerignore"), defaultDockerignore, opts.SourceDate); err != nil {
+			return fmt.Errorf("write default dockerignore: %w", err)
+		}
+	}
+
+	for _, selected := range selection {
+		selected := selected
+
+		eg.Go(func() error {
+			// Render into memory first and write the result atomically, so the build directory never holds a partially
+			// rendered file. A file rendered by a previous run is removed if rendering fails.
+			outputPath := filepath.Join(path, selected.Output)
+
+			var rendered bytes.Buffer
+			if err := selected.Template.Execute(&rendered, data); err != nil {
+				_ = os.Remove(outputPath)
+				return fmt.Errorf("execute template %q: %w", selected.Template.Name(), err)
+			}
+
+			if err := writeRenderedFile(outputPath, rendered.Bytes(), opts.SourceDate); err != nil {
+				return fmt.Errorf("write template %q: %w", selected.Template.Name(), err)
+			}
+
+			return nil
+		})
+	}
+
+	return eg.Wait()
+}
+
+// selectedTemplate is a template along with the name of the file it gets rendered to.
+type selectedTemplate struct {
+	Output   string
+	Template *template.Template
+}
+
+// selectTemplates returns the templates rendered for the given version. Mapped templates are only rendered, as
+// Dockerfile, for their version range; a plain Dockerfile template serves as fallback.
+func selectTemplates(templates *template.Template, version *semver.Version, mappings []versionMapping) []selectedTemplate {
+	mappedTemplates := make(map[string]bool, len(mappings))
+	for _, mapping := range mappings {
+		mappedTemplates[mapping.Value] = true
+	}
+	dockerfileTemplate, _ := matchVersionMapping(mappings, version)
+
+	var selected []selectedTemplate
+	for _, rawTemplate := range templates.Templates() {
+		if !isRenderedTemplate(rawTemplate) {
+			continue
+		}
+
+		outputName := rawTemplate.Name()
+		switch {
+		case outputName == dockerfileTemplate:
+			outputName = "Dockerfile"
+		case mappedTemplates[outputName]:
+			continue // Mapped to a different version range
+		case outputName == "Dockerfile" && dockerfileTemplate != "":
+			continue // Replaced by the mapped template
+		}
+
+		selected = append(selected, selectedTemplate{Output: outputName, Template: rawTemplate})
+	}
+
+	return selected
+}
+
+// newTemplateData returns the data the templates are rendered with for the given version.
+func newTemplateData(version *semver.Version, opts *options) templateData {
+	// Pick the tools for this version
+	tools, ok := matchVersionMapping(opts.ToolMappings, version)
+	if !ok {
+		tools = opts.Tools
+	}
+
+	// TODO: Remove specific use-case
+	installTools := !version.LessThan(semver.MustParse("10.0.0"))
+
+	return templateData{
+		Version:      version.Original(),
+		Maintainer:   opts.Maintainer,
+		Maintainers:  opts.Maintainers,
+		Authors:      strings.Join(opts.Maintainers, ", "),
+		InstallTools: installTools,
+		Tools:        formatTools(tools),
+		Extra:        opts.TemplateVars,
+		DefaultUser:  opts.DefaultUser,
+		ExtraEnv:     opts.ExtraEnv,
+		Revision:     opts.TemplateRevision,
+	}
+}
+
+// formatTools turns a comma-separated list of tools into the space-separated form used by package managers.
+func formatTools(list string) string {
+	var tools []string
+	for _, tool := range strings.Split(list, ",") {
+		if tool = strings.TrimSpace(tool); tool != "" {
+			tools = append(tools, tool)
+		}
+	}
+
+	return strings.Join(tools, " ")
+}
+
+// checkPlatform verifies that the docker daemon can natively build images for the requested platform. Foreign
+// platforms can only be built if the docker host has emulation configured, which the daemon doesn't report, so a
+// mismatch is returned as an error for the caller to decide on.
+func checkPlatform(ctx context.Context, client *docker.Client, platform string) error {
+	native, err := client.Platform(ctx)
+	if err != nil {
+		return err
+	}
+
+	simplog.FromContext(ctx).Debugf("Docker daemon platform: %s", native)
+
+	requested := strings.Split(platform, "/")
+	if strings.Join(requested[:2], "/") == native {
+		return nil
+	}
+
+	return fmt.Errorf("platform %s differs from the docker daemon's platform %s; building it requires QEMU emulation "+
+		"(binfmt_misc) on the docker host, e.g. via \"docker run --privileged --rm tonistiigi/binfmt --install all\"",
+		platform, native)
+}
+
+// buildKitFlags returns the flags set in the given options that need BuildKit.
+func buildKitFlags(opts *options) []string {
+	var flags []string
+	if len(opts.Secrets) > 0 {
+		flags = append(flags, "--secret")
+	}
+
+	if len(opts.SSH) > 0 {
+		flags = append(flags, "--ssh")
+	}
+
+	if opts.OCI {
+		flags = append(flags, "--oci")
+	}
+
+	if opts.Provenance != "" {
+		flags = append(flags, "--provenance")
+	}
+
+	return flags
+}
+
+// checkBuildKitFlags verifies that the docker daemon supports the flags set in the given options that need BuildKit.
+// OCI media types and attestations also need the containerd image store; the daemon's classic image store only keeps
+// single images in Docker media types.
+func checkBuildKitFlags(ctx context.Context, client *docker.Client, opts *options) error {
+	flags := buildKitFlags(opts)
+	if len(flags) == 0 {
+		return nil
+	}
+
+	if client.BuildBackend() != docker.BuildBackendBuildKit {
+		return fmt.Errorf("BuildKit is required by %s, but the docker daemon doesn't use it; pass --build-backend buildkit to force it", strings.Join(flags, ", "))
+	}
+
+	if !opts.OCI && opts.Provenance == "" {
+		return nil
+	}
+
+	containerd, err := client.ContainerdImageStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !containerd {
+		return errors.New("--oci and --provenance need the containerd image store of the docker daemon; enable it with the \"containerd-snapshotter\" feature in the daemon configuration")
+	}
+
+	return nil
+}
+
+// lintBuildDirectory lints the rendered Dockerfile of a build directory with the enabled embedded rules and, if
+// requested, hadolint.
+func lintBuildDirectory(ctx context.Context, buildDirectory string, dockerfile []byte, rules []string, useHadolint bool) ([]lintFinding, error) {
+	findings := lintDockerfile(dockerfile, rules)
+	if !useHadolint {
+		return findings, nil
+	}
+
+	hadolintFindings, err := runHadolint(ctx, filepath.Join(buildDirectory, "Dockerfile"))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(findings, hadolintFindings...), nil
+}
+
+// findExistingImage reports where the given image exists: "remotely", "locally" or, if it doesn't exist at all, an
+// empty string. The registry is asked first, as that's where images end up. Lookup errors count as missing, so an
+// unreachable registry leads to a rebuild instead of a skip.
+func findExistingImage(ctx context.Context, images docker.ImageClient, ref string) string {
+	logger := simplog.FromContext(ctx)
+
+	_, err := images.RemoteDigest(ctx, ref)
+	if err == nil {
+		return "remotely"
+	}
+	logger.Debugf("Image %s not found remotely: %v", ref, err)
+
+	if _, err := images.Inspect(ctx, ref); err == nil {
+		return "locally"
+	}
+
+	return ""
+}
+
+// writeDigestFile writes the given tag to digest mapping as JSON object to path, atomically.
+func writeDigestFile(path string, digests map[string]string) error {
+	content, err := json.MarshalIndent(digests, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode digests: %w", err)
+	}
+
+	return writeFileAtomic(path, append(content, '\n'), 0o644)
+}
+
+// verifyPush checks that every given tag resolves to the digest reported by its push in the registry. This catches
+// registry issues the push stream didn't report.
+func verifyPush(ctx context.Context, images docker.ImageClient, tags []string, digests map[string]string) error {
+	for _, tag := range tags {
+		pushed, ok := digests[tag]
+		if !ok {
+			return fmt.Errorf("no digest reported for pushed tag %s", tag)
+		}
+
+		remote, err := images.RemoteDigest(ctx, tag)
+		if err != nil {
+			return fmt.Errorf("resolve tag %s: %w", tag, err)
+		}
+
+		if remote != pushed {
+			return fmt.Errorf("tag %s resolves to %s, but %s was pushed", tag, remote, pushed)
+		}
+	}
+
+	return nil
+}
+
+// pushStaging tags the given image with the staging tag, pushes it and, optionally, verifies the push. The local
+// staging tag is removed afterwards; the image keeps its other tags.
+func pushStaging(ctx context.Context, images docker.ImageClient, imageID, stagingTag string, verify bool) error {
+	logger := simplog.FromContext(ctx)
+
+	if err := images.Tag(ctx, imageID, stagingTag); err != nil {
+		return err
+	}
+	defer func() {
+		if err := images.Untag(ctx, stagingTag); err != nil {
+			logger.Warnf("Failed to remove local staging tag: %v", err)
+		}
+	}()
+
+	logger.Infof("Pushing staging image %s", stagingTag)
+	digests, err := images.Push(ctx, stagingTag)
+	if err != nil {
+		return err
+	}
+
+	if verify {
+		logger.Infof("Verifying push of staging image %s", stagingTag)
+		if err = verifyPush(ctx, images, []string{stagingTag}, digests); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pushTags pushes the given tags one by one and handles tags the registry refuses to overwrite according to policy:
+// "fail" returns the error, "skip" stops pushing, and "force" carries on with the remaining tags. It returns the digests
+// of the pushed tags and the tags that were refused.
+func pushTags(ctx context.Context, images docker.ImageClient, tags []string, policy string) (map[string]string, []string, error) {
+	logger := simplog.FromContext(ctx)
+
+	digests := make(map[string]string, len(tags))
+	var refused []string
+	for _, tag := range tags {
+		pushed, err := images.Push(ctx, tag)
+		if err == nil {
+			maps.Copy(digests, pushed)
+			continue
+		}
+
+		if !errors.Is(err, docker.ErrTagImmutable) || policy == immutableFail {
+			return nil, nil, err
+		}
+
+		refused = append(refused, tag)
+		if policy == immutableSkip {
+			break
+		}
+
+		logger.Warnf("Registry refused to overwrite immutable tag %s; pushing the remaining tags", tag)
+	}
+
+	return digests, refused, nil
+}
+
+// pushManifestLists pushes a manifest list of the given platform images under each of the given tags, which are
+// references into the same repository, and handles tags the registry refuses to overwrite like pushTags does. It
+// returns the digests of the pushed tags and the tags that were refused.
+func pushManifestLists(ctx context.Context, manifests docker.ManifestClient, repo string, images []docker.PlatformImage, tags []string, policy string) (map[string]string, []string, error) {
+	logger := simplog.FromContext(ctx)
+
+	digests := make(map[string]string, len(tags))
+	var refused []string
+	for _, tag := range tags {
+		dgst, err := manifests.PushManifestList(ctx, repo, images, strings.TrimPrefix(tag, repo+":"))
+		if err == nil {
+			digests[tag] = dgst
+			continue
+		}
+
+		if !errors.Is(err, docker.ErrTagImmutable) || policy == immutableFail {
+			return nil, nil, err
+		}
+
+		refused = append(refused, tag)
+		if policy == immutableSkip {
+			break
+		}
+
+		logger.Warnf("Registry refused to overwrite immutable tag %s; pushing the remaining tags", tag)
+	}
+
+	return digests, refused, nil
+}
+
+// checkImageMetadata verifies that the given image has all expected labels and environment variables.
+func checkImageMetadata(info types.ImageInspect, labels, env map[string]string) error {
+	if info.Config == nil {
+		if len(labels) > 0 || len(env) > 0 {
+			return errors.New("image has no config")
+		}
+
+		return nil
+	}
+
+	for key, want := range labels {
+		got, ok := info.Config.Labels[key]
+		if !ok {
+			return fmt.Errorf("label %q is missing", key)
+		}
+		if got != want {
+			return fmt.Errorf("label %q is %q, expected %q", key, got, want)
+		}
+	}
+
+	imageEnv, err := parseKeyValues(info.Config.Env)
+	if err != nil {
+		return fmt.Errorf("parse image environment: %w", err)
+	}
+
+	for key, want := range env {
+		got, ok := imageEnv[key]
+		if !ok {
+			return fmt.Errorf("environment variable %q is missing", key)
+		}
+		if got != want {
+			return fmt.Errorf("environment variable %q is %q, expected %q", key, got, want)
+		}
+	}
+
+	return nil
+}
+
+// postBuildDockerfile returns the Dockerfile applying the post-build snippet to the given image.
+func postBuildDockerfile(imageID string, snippet []byte) []byte {
+	return append([]byte(fmt.Sprintf("FROM %s\n", imageID)), snippet...)
+}
+
+// preparePostBuildDirectory creates a build directory with a Dockerfile that applies the given snippet on top of the
+// given image.
+func preparePostBuildDirectory(path, imageID string, snippet []byte) error {
+	if err := os.MkdirAll(path, 0o750); err != nil {
+		return fmt.Errorf("create post-build directory: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(path, "Dockerfile"), postBuildDockerfile(imageID, snippet), 0o600); err != nil {
+		return fmt.Errorf("write post-build Dockerfile: %w", err)
+	}
+
+	return nil
+}
+
+// evictImages returns the images that exceed the given number of images to keep, oldest first, and the remaining kept
+// images. Base images that are still used by a kept image are not evicted; with keepBases, no base image is.
+func evictImages(images []builtImage, keep int, keepBases bool) ([]string, []builtImage) {
+	if len(images) <= keep {
+		return nil, images
+	}
+
+	evicted, kept := images[:len(images)-keep], images[len(images)-keep:]
+
+	keptBases := make(map[string]bool, len(kept))
+	for _, image := range kept {
+		keptBases[image.BaseID] = true
+	}
+
+	ids := make([]string, 0, 2*len(evicted))
+	for _, image := range evicted {
+		ids = append(ids, image.ID)
+		if image.SnippetBaseID != "" {
+			ids = append(ids, image.SnippetBaseID)
+		}
+
+		if !keepBases && !keptBases[image.BaseID] && !slices.Contains(ids, image.BaseID) {
+			ids = append(ids, image.BaseID)
+		}
+	}
+
+	return ids, kept
+}
+
+func cleanupBuildDirs(ctx context.Context, dirs []string) {
+	logger := simplog.FromContext(ctx)
+
+	for _, dir := range dirs {
+		dir = filepath.FromSlash(dir)
+
+		logger.Debugf("Removing build directory %s", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Errorf("Failed to remove build directory %s: %v", dir, err)
+		}
+	}
+}
+
+func exportImage(ctx context.Context, images docker.ImageClient, dir, version, ref string) error {
+	// Create export directory
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("create export directory: %w", err)
+	}
+
+	// Open tar file for version
+	path := filepath.Join(dir, version+".tar")
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create export file: %w", err)
+	}
+
+	if err = images.Save(ctx, ref, file); err != nil {
+		_ = file.Close()
+		_ = os.Remove(path) // Don't leave a partial archive behind
+
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("close export file: %w", err)
+	}
+
+	return nil
+}
+
+func loadImages(ctx context.Context, images docker.ImageClient, paths []string) error {
+	logger := simplog.FromContext(ctx)
+
+	for _, path := range paths {
+		file, err := os.Open(filepath.FromSlash(path))
+		if err != nil {
+			return fmt.Errorf("open image archive: %w", err)
+		}
+
+		refs, err := images.Load(ctx, file)
+		_ = file.Close()
+		if err != nil {
+			return fmt.Errorf("load image archive %s: %w", path, err)
+		}
+
+		logger.Infof("Loaded %s from %s", strings.Join(refs, ", "), path)
+	}
+
+	return nil
+}
+
+// login logs in to the registry of the target repository. An auth config, given as file or via DOCKER_AUTH_CONFIG,
+// takes precedence over the DOCKER_USERNAME and DOCKER_PASSWORD variables.
+func login(ctx context.Context, client *docker.Client, opts *options) error {
+	var authConfig []byte
+	if opts.AuthConfig != "" {
+		var err error
+		authConfig, err = os.ReadFile(opts.AuthConfig)
+		if err != nil {
+			return fmt.Errorf("read auth config: %w", err)
+		}
+	} else if env := os.Getenv("DOCKER_AUTH_CONFIG"); env != "" {
+		authConfig = []byte(env)
+	}
+
+	if authConfig == nil {
+		return client.LoginFromEnv(ctx)
+	}
+
+	return client.LoginFromAuthConfig(ctx, authConfig, docker.RegistryHost(opts.TargetRepo))
+}
+
+// runSubcommand runs the subcommand named by the first argument, if any. It reports whether there was a subcommand.
+// An argument naming an existing file or directory is the templates argument, not a subcommand; e.g. a template
+// directory called "images".
+func runSubcommand(ctx context.Context, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	if _, err := os.Stat(args[0]); err == nil {
+		return false, nil
+	}
+
+	switch args[0] {
+	case "cache":
+		return true, runCacheCommand(ctx, args[1:])
+	case "template":
+		return true, runTemplateCommand(args[1:])
+	case "images":
+		return true, runImagesCommand(ctx, args[1:])
+	}
+
+	return false, nil
+}
+
+func main() {
+	os.Exit(run())
+}
+
+// run runs mimikry and returns its exit code. It's separate from main, so the deferred cleanups run before exiting.
+func run() int {
+	// Create signal cancel context
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	defer cancel()
+
+	// Subcommands bring their own flags
+	if handled, err := runSubcommand(ctx, os.Args[1:]); handled {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	// Get options from CLI
+	opts, err := optionsFromCLI()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if opts.PrintVersion {
+		fmt.Println(versionInfo())
+		return 0
+	}
+
+	// Setup logger
+	logger := simplog.NewClientLogger(opts.Debug)
+	if opts.SummaryOnChange && !opts.Debug {
+		// Hold back the output of quiet runs until it's clear whether they changed anything. Runs that end before
+		// deciding show everything.
+		var held *heldLogs
+		logger, held = holdLogs(logger)
+		defer held.release(zapcore.DebugLevel)
+		ctx = withHeldLogs(ctx, held)
+	}
+	ctx = simplog.WithLogger(ctx, logger)
+
+	// Identify ourselves to the registry API
+	docker.SetUserAgent(opts.UserAgent)
+	docker.SetRateLimit(opts.RegistryRPS)
+	docker.SetMaxTagPages(opts.MaxTagPages)
+
+	if opts.Proxy != "" {
+		if err = docker.SetProxy(opts.Proxy); err != nil {
+			logger.Error(err)
+			return 1
+		}
+	}
+
+	switch {
+	case opts.RecordTags != "":
+		docker.RecordRegistryResponses(opts.RecordTags)
+	case opts.ReplayTags != "":
+		if err = docker.ReplayRegistryResponses(opts.ReplayTags); err != nil {
+			logger.Error(err)
+			return 1
+		}
+	}
+
+	// Clone templates from git if requested
+	if opts.TemplateGit != "" {
+		logger.Info("Cloning templates")
+		opts.TemplatePath, err = cloneTemplates(ctx, opts.TemplateGit)
+		if err != nil {
+			logger.Error(err)
+			return 1
+		}
+		defer func() { _ = os.RemoveAll(opts.TemplatePath) }()
+	}
+
+	// Label the images with the commit of the templates, if they come from a git working tree
+	templateDir := opts.TemplatePath
+	if opts.Dockerfile != "" {
+		templateDir = filepath.Dir(opts.Dockerfile)
+	}
+
+	if !opts.FromBuildDir && opts.Dockerfile != "-" {
+		if opts.TemplateRevision, err = gitRevision(ctx, templateDir); err != nil {
+			logger.Debugf("Templates are not in a git working tree; not setting the %s label: %v", labelRevision, err)
+		} else {
+			logger.Debugf("Templates are at git commit %s", opts.TemplateRevision)
+		}
+	}
+
+	// Parse all template files in the template directory or the single Dockerfile template; not needed if the build
+	// directories are rendered already
+	templates := template.New("")
+	switch {
+	case opts.Dockerfile != "":
+		opts.DockerfileSource, err = readDockerfileTemplate(opts.Dockerfile)
+		if err == nil {
+			templates, err = template.New("Dockerfile").Parse(string(opts.DockerfileSource))
+		}
+	case !opts.FromBuildDir:
+		templates, err = parseTemplates(opts.TemplatePath)
+	}
+	if err != nil {
+		logger.Error(err)
+		return 1
+	}
+
+	// Build on the image the Dockerfile template builds on, unless told otherwise
+	if opts.DetectSourceRepo && !opts.FromBuildDir {
+		if repo, err := parseSourceRepoFromTemplate(templates); err != nil {
+			logger.Warnf("Can't detect the source repository from the Dockerfile template: %v; using %s, pass --source to set it", err, opts.SourceRepos[0])
+		} else {
+			logger.Debugf("Detected source repository %s in the Dockerfile template", repo)
+			opts.SourceRepos = []string{repo}
+		}
+	}
+
+	// Cap the total run time if requested
+	if opts.Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, opts.Timeout)
+		defer cancelTimeout()
+	}
+
+	// Make sure all mapped templates exist
+	for _, mapping := range opts.TemplateMappings {
+		if templates.Lookup(mapping.Value) == nil {
+			logger.Errorf("Template %q mapped via --template-for not found in %s", mapping.Value, opts.TemplatePath)
+			return 1
+		}
+	}
+
+	// Run main
+	err = realMain(ctx, templates, opts)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		logger.Errorf("Run aborted; global timeout of %s exceeded", opts.Timeout)
+		return 1
+	}
+
+	if errors.Is(err, ErrNothingToDo) {
+		logger.Info("Nothing to do; all versions were skipped")
+		return exitCodeFor(err)
+	}
+
+	if err != nil && !errors.Is(err, context.Canceled) {
+		logger.Error(err)
+		return exitCodeFor(err)
+	}
+
+	return 0
+}
+
+func realMain(ctx context.Context, templates *template.Template, opts *options) (retErr error) {
+	logger := simplog.FromContext(ctx)
+
+	// Collect the results of all versions and write them as a report once the run ends, successful or not
+	report := &runReport{
+		TargetRepo: opts.TargetRepo,
+		SourceRepo: strings.Join(opts.SourceRepos, ", "),
+		Version:    getVersion(),
+		Started:    time.Now(),
+	}
+
+	// The changes compared to the previous runs; only known once the run completed
+	var changes *changelog
+
+	if opts.ReportFormat != "" || opts.SlackWebhook != "" {
+		defer func() {
+			report.Duration = time.Since(report.Started)
+			if retErr != nil && !errors.Is(retErr, ErrNothingToDo) {
+				report.Error = retErr.Error()
+			}
+
+			for _, result := range report.Results {
+				if result.Status == "" {
+					result.Status = statusFailed
+					result.Error = report.Error
+				}
+			}
+
+			if opts.ReportFormat != "" {
+				logger.Infof("Writing %s report to %s", opts.ReportFormat, opts.ReportFile)
+				if err := writeReport(opts.ReportFormat, opts.ReportFile, report); err != nil {
+					logger.Errorf("Failed to write report: %v", err)
+				}
+			}
+
+			// A failed notification doesn't fail the run; notify even if the run has been interrupted
+			if opts.SlackWebhook != "" {
+				logger.Info("Sending Slack notification")
+				if err := notifySlack(context.WithoutCancel(ctx), opts.SlackWebhook, newSlackMessage(report, changes)); err != nil {
+					logger.Warnf("Failed to send Slack notification: %v", err)
+				}
+			}
+		}()
+	}
+
+	// Collect the digests of all pushed tags and write them once the run ends, so even a failed run leaves a record of
+	// what it pushed
+	pushedDigests := make(map[string]string)
+	if opts.DigestFile != "" && !opts.DryRun {
+		defer func() {
+			logger.Infof("Writing digests to %s", opts.DigestFile)
+			if err := writeDigestFile(opts.DigestFile, pushedDigests); err != nil {
+				logger.Errorf("Failed to write digest file: %v", err)
+			}
+		}()
+	}
+
+	// Turn panics into errors. The deferred tag cache save and build directory cleanup run while unwinding, so a bug in
+	// a long run doesn't cost us the cache.
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("Recovered from panic: %v\n%s", r, debug.Stack())
+			retErr = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	// Use a fresh build directory for this run if requested; it's removed with everything in it once the run is done
+	if opts.TempBuild && !opts.InMemoryContext {
+		dir, err := os.MkdirTemp("", "mimikry-build-")
+		if err != nil {
+			return fmt.Errorf("create temporary build directory: %w", err)
+		}
+
+		logger.Debugf("Using temporary build directory %s", dir)
+		opts.BuildDir = dir
+		defer cleanupBuildDirs(ctx, []string{dir})
+	}
+
+	// Fail fast if the build or cache directory isn't writable, instead of after fetching tags and logging in
+	writableDirs := []string{filepath.FromSlash(tagCacheDirectory)}
+	if !opts.InMemoryContext {
+		writableDirs = append(writableDirs, opts.BuildDir)
+	}
+
+	for _, dir := range writableDirs {
+		logger.Debugf("Checking that %s is writable", dir)
+		if err := checkWritable(dir); err != nil {
+			return err
+		}
+	}
+
+	// Fail fast if the source images can't be verified at all
+	if opts.VerifyBase && !opts.EmitCommands && !opts.Explain && !cosignAvailable() {
+		return errors.New("--verify-base requires cosign to be installed")
+	}
+
+	// Derive the versions constraint from the supported release cycles if none was given
+	if opts.AutoConstraint && opts.VersionConstraint == "" && len(opts.Versions) == 0 {
+		logger.Debug("Deriving version constraint from supported release cycles")
+		constraint, err := supportedConstraint(ctx, newEndOfLifeDateProvider(), opts.SourceRepos[0])
+		if err != nil {
+			return fmt.Errorf("derive version constraint: %w", err)
+		}
+		opts.VersionConstraint = constraint
+		logger.Infof("Using version constraint %q derived from supported release cycles", opts.VersionConstraint)
+	}
+
+	// Parse the versions constraint; without one, all versions match
+	rawConstraint := opts.VersionConstraint
+	if rawConstraint == "" {
+		rawConstraint = "*"
+	}
+
+	versionConstraint, err := semver.NewConstraint(rawConstraint)
+	if err != nil {
+		return fmt.Errorf("parse version constraint: %w", err)
+	}
+	logger.Debugf("Parsed version constraint: %s", versionConstraint)
+
+	// Create docker client; not needed if we only emit the docker commands or explain the tag selection
+	var client *docker.Client
+	if !opts.EmitCommands && !opts.Explain {
+		logger.Debug("Creating docker client")
+		clientOpts := []docker.Option{
+			docker.WithBaseStrategy(docker.BaseStrategy(opts.BaseStrategy)),
+			docker.WithBuildBackend(docker.BuildBackend(opts.BuildBackend)),
+			docker.WithRemoveConcurrency(opts.RemoveConcurrency),
+			docker.WithReconnect(opts.Reconnect),
+		}
+		if opts.DockerHost != "" {
+			clientOpts = append(clientOpts, docker.WithHost(opts.DockerHost))
+		}
+
+		client, err = docker.New(ctx, clientOpts...)
+		if err != nil {
+			return fmt.Errorf("create docker client: %w", err)
+		}
+		defer func() { _ = client.Close(ctx) }()
+
+		if err = checkBuildKitFlags(ctx, client, opts); err != nil {
+			return err
+		}
+
+		// Check upfront whether the requested platforms can be built, instead of failing deep inside the first build
+		for _, platform := range requestedPlatforms(opts) {
+			if err = checkPlatform(ctx, client, platform); err != nil {
+				if opts.Strict {
+					return fmt.Errorf("check platform: %w", err)
+				}
+				logger.Warn(err)
+			}
+		}
+
+		// Login
+		if !opts.DryRun {
+			logger.Info("Logging in to docker")
+			if err = login(ctx, client, opts); err != nil {
+				return fmt.Errorf("login to docker: %w", err)
+			}
+			defer func() { _ = client.Logout(ctx) }()
+		} else {
+			logger.Info("Dry run enabled; skipping authentication")
+		}
+
+		// Load images from archives, e.g. base images in air-gapped environments
+		if len(opts.LoadArchives) > 0 {
+			logger.Info("Loading images from archives")
+			if err = loadImages(ctx, client.Images(), opts.LoadArchives); err != nil {
+				return fmt.Errorf("load images: %w", err)
+			}
+		}
+	}
+
+	// Read the post-build snippet upfront, so we don't fail after the first build
+	var postBuildSnippet []byte
+	if opts.PostBuildSnippet != "" {
+		postBuildSnippet, err = os.ReadFile(opts.PostBuildSnippet)
+		if err != nil {
+			return fmt.Errorf("read post-build snippet: %w", err)
+		}
+	}
+
+	sourceRepo := opts.SourceRepos[0]
+	cachePath := tagCachePath(opts.SourceRepos)
+
+	var tags *imageTags
+	switch {
+	case opts.FromBuildDir:
+		// The versions are given by the rendered build directories
+		logger.Debugf("Loading versions from build directory %s", opts.BuildDir)
+		tags, err = buildDirTags(opts.BuildDir)
+		if err != nil {
+			return fmt.Errorf("load versions from build directory: %w", err)
+		}
+	case opts.NoCacheFile:
+		// Bypass the tag cache entirely; the tags only live in memory for this run
+		logger.Info("Loading image tags")
+		logger.Debug("Tag cache disabled; loading remote tags")
+		tags, err = fetchRepoTags(ctx, opts.SourceRepos, nil, nil)
+		if err != nil {
+			return fmt.Errorf("load remote tags: %w", err)
+		}
+	default:
+		// Try to load tags from cache
+		logger.Info("Loading image tags")
+		logger.Debug("Trying to load tag cache")
+
+		// Hold the cache lock while loading and, if needed, fetching the tags, so parallel jobs for the same source
+		// repositories fetch them only once
+		unlockCache, err := lockTagCache(ctx, cachePath)
+		if err != nil {
+			return fmt.Errorf("lock tag cache: %w", err)
+		}
+
+		var partial *imageTags
+		if opts.RefreshTags {
+			logger.Debug("Refreshing tags; ignoring tag cache")
+		} else {
+			tags, err = loadTagCache(cachePath)
+			if err != nil {
+				logger.Debugf("Failed to load tag cache: %v", err)
+			} else if tags.Image != tagCacheImage(opts.SourceRepos) {
+				logger.Debugf("Ignoring tag cache of %s", tags.Image)
+				tags = nil
+			} else if tags.Cursor != nil {
+				logger.Debug("Tag cache is incomplete; an earlier fetch was interrupted")
+				partial, tags = tags, nil
+			}
+		}
+
+		if tags != nil {
+			logger.Debug("Using tag cache")
+		} else {
+			logger.Debug("No tag cache found; loading remote tags")
+			// Persist the progress after every page if requested, so an interrupted fetch can be resumed
+			var checkpoint func(*imageTags)
+			if opts.ResumableFetch {
+				checkpoint = func(cache *imageTags) {
+					if err := saveTagCache(cachePath, cache); err != nil {
+						logger.Warnf("Failed to save tag fetch progress: %v", err)
+					}
+				}
+			} else {
+				partial = nil
+			}
+
+			tags, err = fetchRepoTags(ctx, opts.SourceRepos, partial, checkpoint)
+			if err != nil {
+				unlockCache()
+				return fmt.Errorf("load remote tags: %w", err)
+			}
+
+			if err = saveTagCache(cachePath, tags); err != nil {
+				logger.Errorf("Failed to save tag cache: %v", err)
+			}
+		}
+		unlockCache()
+	}
+
+	numTags := len(tags.Tags)
+	logger.Debugf("Loaded %d tags", numTags)
+
+	// Pre-sort and -filter tags; this does worsen the performance technically, but it avoids a lot
+	// of issues down the line.
+	versions := make([]*semver.Version, 0, numTags)
+	normalizedTags := make(map[string]string, numTags)
+	decisions := newTagDecisions()
+	var newest *semver.Version
+	for _, tag := range tags.Tags {
+		// Sanitize tag and skip if it's not a major.minor version
+		tag = strings.TrimSpace(tag)
+		if stdSkipTagFunc(tag) {
+			// Not removing the tag from the list as it might be requested by the user later
+			decisions.skipTag(ctx, tag, "not a major[.minor[.patch]] version")
+			continue
+		}
+
+		parseVersion := semver.NewVersion
+		if opts.StrictSemver {
+			parseVersion = semver.StrictNewVersion
+		}
+
+		version, err := parseVersion(tag)
+		if err != nil {
+			decisions.skipInvalid(ctx, tag, err)
+			continue
+		}
+
+		if newest == nil || version.GreaterThan(newest) {
+			newest = version
+		}
+
+		// Lenient parsing maps e.g. 16 and 16.0 to the same version; make such ambiguities visible
+		if opts.StrictSemver {
+			if other, ok := normalizedTags[version.String()]; ok {
+				logger.Warnf("Tags %s and %s both normalize to version %s", other, tag, version)
+			}
+			normalizedTags[version.String()] = tag
+		}
+
+		// Check if the version is listed explicitly or matches the constraint
+		if len(opts.Versions) > 0 {
+			if !slices.Contains(opts.Versions, tag) {
+				decisions.skipTag(ctx, tag, "not listed in --versions")
+				continue
+			}
+		} else if !versionConstraint.Check(version) {
+			decisions.skipTag(ctx, tag, fmt.Sprintf("does not match constraint %q", rawConstraint))
+			continue
+		}
+
+		// Finally, add the version to the list
+		decisions.selectTag(ctx, tag)
+		versions = append(versions, version)
+	}
+
+	// Report tags that look like versions but aren't; they might be versions mimikry misses
+	report.IgnoredTags = decisions.invalid
+	if opts.StrictTags && len(decisions.invalid) > 0 {
+		ignored := make([]string, 0, len(decisions.invalid))
+		for _, invalid := range decisions.invalid {
+			ignored = append(ignored, invalid.Tag)
+		}
+
+		return fmt.Errorf("%d tags could not be parsed as versions: %s", len(ignored), strings.Join(ignored, ", "))
+	}
+
+	// Tell apart a constraint asking for versions that don't exist yet from one that just matches nothing
+	if len(versions) == 0 && len(opts.Versions) == 0 && newest != nil {
+		if bound, ok := constraintLowerBound(rawConstraint); ok && bound.exceeds(newest) {
+			return fmt.Errorf("constraint requires %s but newest available is %s", bound.Term, newest.Original())
+		}
+	}
+
+	// All explicitly listed versions must exist
+	for _, listed := range opts.Versions {
+		if !slices.ContainsFunc(versions, func(v *semver.Version) bool { return v.Original() == listed }) {
+			return fmt.Errorf("version %s not found in the tags of %s", listed, tagCacheImage(opts.SourceRepos))
+		}
+	}
+
+	// Drop end-of-life versions, unless they're wanted or listed explicitly. Images endoflife.date doesn't know keep
+	// all versions; so do all runs if it can't be reached.
+	if !opts.IncludeEOL && len(opts.Versions) == 0 {
+		logger.Debug("Filtering end-of-life versions")
+		supported, err := filterEOLVersions(ctx, newEndOfLifeDateProvider(), sourceRepo, versions)
+		switch {
+		case errors.Is(err, errUnknownProduct):
+			logger.Debugf("Keeping all versions; %v", err)
+		case err != nil:
+			logger.Warnf("Keeping all versions; failed to filter end-of-life versions: %v", err)
+		default:
+			decisions.skipDropped(ctx, versions, supported, "release cycle is end-of-life")
+			versions = supported
+		}
+	}
+
+	sort.Sort(semver.Collection(versions))
+
+	// Apply the version preset, if any; presets work on the sorted versions
+	if opts.Preset != "" {
+		logger.Debugf("Applying version preset %s", opts.Preset)
+		preset, err := applyVersionPreset(ctx, opts.Preset, sourceRepo, versions)
+		if err != nil {
+			return fmt.Errorf("apply version preset: %w", err)
+		}
+		decisions.skipDropped(ctx, versions, preset, fmt.Sprintf("not part of preset %s", opts.Preset))
+		versions = preset
+	}
+
+	numTags = len(versions)
+	logger.Debugf("%d tags after sorting and filtering", numTags)
+
+	// Determine the latest version before the build order is applied, so it doesn't depend on it
+	latestVersion := highestVersion(versions)
+	if opts.SortOrder == sortDesc {
+		slices.Reverse(versions)
+	}
+
+	// Only explain the tag selection, if requested
+	if opts.Explain {
+		decisions.print(os.Stdout)
+		return nil
+	}
+
+	// Only print the docker commands, if requested
+	if opts.EmitCommands {
+		return emitCommands(versions, latestVersion, templates, opts)
+	}
+
+	// Load the state of a previous, interrupted run to resume from. Without templates, the state is bound to the build
+	// directory instead.
+	templateHash := "build-dir:" + opts.BuildDir
+	switch {
+	case opts.Dockerfile != "":
+		templateHash = hashDockerfileTemplate(opts.DockerfileSource)
+	case !opts.FromBuildDir:
+		templateHash, err = hashTemplates(opts.TemplatePath)
+		if err != nil {
+			return fmt.Errorf("hash templates: %w", err)
+		}
+	}
+
+	state, err := loadRunState(opts.TargetRepo, templateHash)
+	if err != nil {
+		return fmt.Errorf("load run state: %w", err)
+	}
+
+	if opts.Force {
+		state.Completed = nil
+	} else if len(state.Completed) > 0 {
+		logger.Infof("Resuming previous run; %d versions already completed", len(state.Completed))
+	}
+
+	// Build directory tree and generate Dockerfile from template for each version
+	logger.Info("Building and uploading images")
+
+	// Cleanup build directories once done. The tag cache has been saved right after fetching the tags already.
+	var pathsToCleanup []string
+	defer func() {
+		cleanupBuildDirs(ctx, pathsToCleanup)
+	}()
+
+	// Build and push all images
+	keptImages := make([]builtImage, 0, opts.KeepImages+1)
+	buildOptions := newBuildOptions(opts)
+	numPushed := 0
+
+	// Find versions that share the same source image, so they can be built once
+	var aliasOf map[string]string
+	var aliases map[string][]string
+	if opts.DedupTags {
+		logger.Info("Resolving source image digests")
+		aliasOf, aliases = dedupVersions(ctx, client.Images(), sourceRepo, versions)
+	}
+
+	// Find the newest version of each major and minor version; they receive the rolling tags
+	rolling := rollingTags(versions, rollingGranularities(opts)...)
+	for version, tags := range rolling {
+		logger.Debugf("Version %s receives the rolling tags %s", version, strings.Join(tags, ", "))
+	}
+
+	useHadolint := false
+	if opts.Lint {
+		// Hadolint reads the Dockerfile from disk, which an in-memory build context doesn't have
+		useHadolint = hadolintAvailable() && !opts.InMemoryContext
+		if !useHadolint && len(opts.LintRules) == 0 {
+			logger.Warn("Linting enabled, but hadolint is not installed and no embedded lint rules are enabled")
+		}
+	}
+
+	sourcePushed := tags.Pushed
+
+	// Load the inputs of the previous builds, to skip versions whose inputs haven't changed
+	var records *buildRecords
+	if opts.SkipUnchanged && !opts.Force {
+		if records, err = loadBuildRecords(opts.TargetRepo); err != nil {
+			return fmt.Errorf("load build records: %w", err)
+		}
+	}
+
+	var failures []error
+	for idx, version := range versions {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		logger.Debugf("Processing tag %d/%d: %s", idx+1, numTags, version)
+
+		// Lead every line logged for this version with the version itself
+		ctx := withVersionLogger(ctx, version.Original())
+		logger := simplog.FromContext(ctx)
+
+		result := &versionResult{Version: version.Original()}
+		report.Results = append(report.Results, result)
+		started := time.Now()
+
+		versionErr := func() error {
+			if state.isCompleted(version.Original()) {
+				logger.Infof("Skipping version %s; completed by a previous run", version.Original())
+				result.Status = statusSkipped
+				return nil
+			}
+
+			if opts.OnlyMissing && !opts.Force {
+				targetImage := fmt.Sprintf("%s:%s", opts.TargetRepo, version.Original())
+				if location := findExistingImage(ctx, client.Images(), targetImage); location != "" {
+					logger.Infof("Skipping version %s; image %s exists %s", version.Original(), targetImage, location)
+					result.Status = statusSkipped
+					return nil
+				}
+			}
+
+			if canonical, ok := aliasOf[version.Original()]; ok {
+				logger.Infof("Skipping version %s; tagged as part of identical version %s", version.Original(), canonical)
+				result.Status = statusSkipped
+				return nil
+			}
+
+			// Create build directory, unless it has been rendered already or the build context is kept in memory
+			buildDirectory, contextFiles, err := prepareBuildContext(version, templates, opts)
+			if err != nil {
+				return err
+			}
+
+			// If the user does not want to keep the build directories, add them to the cleanup list. Directories we
+			// didn't render are never removed.
+			if !opts.KeepBuildDirs && !opts.FromBuildDir && !opts.InMemoryContext {
+				pathsToCleanup = append(pathsToCleanup, buildDirectory)
+			}
+
+			// Make sure the rendered Dockerfile actually builds on top of the version we're about to tag
+			dockerfile, ok := contextFiles["Dockerfile"]
+			if !opts.InMemoryContext {
+				dockerfile, err = os.ReadFile(filepath.Join(buildDirectory, "Dockerfile"))
+				if err != nil {
+					return fmt.Errorf("read rendered Dockerfile: %w", err)
+				}
+			} else if !ok {
+				return errors.New("render build context: no Dockerfile template")
+			}
+
+			if err = checkFromVersion(dockerfile, version); err != nil {
+				if opts.Strict {
+					return fmt.Errorf("check Dockerfile: %w", err)
+				}
+				logger.Warnf("Rendered Dockerfile for version %s: %v", version.Original(), err)
+			}
+
+			// Tag the image for all identical versions and their rolling tags as well and, if this is the latest
+			// image, with the latest tag
+			latestTag := ""
+			if opts.TagLatest && (version.Original() == latestVersion || slices.Contains(aliases[version.Original()], latestVersion)) {
+				latestTag = opts.LatestTag
+			}
+
+			extraTags := slices.Clone(aliases[version.Original()])
+			for _, tagged := range append([]string{version.Original()}, extraTags...) {
+				extraTags = append(extraTags, rolling[tagged]...)
+			}
+
+			tags := imageTagsFor(opts.TargetRepo, version.Original(), extraTags, latestTag)
+			imageTag := tags[0]
+			if latestTag != "" {
+				logger.Infof("Tagging image %s as %s", imageTag, latestTag)
+			}
+			result.Tags = tags
+
+			// Lint the rendered Dockerfile
+			if opts.Lint {
+				findings, err := lintBuildDirectory(ctx, buildDirectory, dockerfile, opts.LintRules, useHadolint)
+				if err != nil {
+					return fmt.Errorf("lint Dockerfile: %w", err)
+				}
+
+				for _, finding := range findings {
+					logger.Warnf("Dockerfile for version %s, line %d: %s %s", version.Original(), finding.Line, finding.Rule, finding.Message)
+				}
+
+				if opts.StrictLint && len(findings) > 0 {
+					return fmt.Errorf("lint Dockerfile: %d findings for version %s", len(findings), version.Original())
+				}
+			}
+
+			// Run the user's own checks against the rendered Dockerfile
+			if opts.ValidateHook != nil {
+				data := hookData{Version: version.Original(), Path: filepath.Join(buildDirectory, "Dockerfile")}
+				if err = runCommandHook(ctx, opts.ValidateHook, data); err != nil {
+					return fmt.Errorf("validate Dockerfile: %w", err)
+				}
+			}
+
+			// Build image
+			versionBuildOptions := withVersionBuildArg(buildOptions, opts.VersionBuildArg, version.Original())
+			platforms := platformsFor(opts, version)
+			versionBuildOptions.Platform = strings.Join(platforms, ",")
+			if opts.CreatedFromSource {
+				labels := make(map[string]string, len(versionBuildOptions.Labels)+1)
+				maps.Copy(labels, versionBuildOptions.Labels)
+				maps.Copy(labels, createdLabels(ctx, sourcePushed, version.Original()))
+				versionBuildOptions.Labels = labels
+			}
+
+			// Skip the build if its inputs haven't changed since the last pushed build, which the tag still points at
+			var inputsHash string
+			if records != nil {
+				inputs := buildInputs{Files: contextFiles, Options: versionBuildOptions, Tags: tags, PostBuildSnippet: postBuildSnippet}
+				inputsHash, err = inputs.resolveHash(ctx, client.Images(), sourceRepo+":"+version.Original(), buildDirectory)
+				if err != nil {
+					logger.Warnf("Failed to hash build inputs: %v", err)
+				} else if records.upToDate(ctx, client.Images(), version.Original(), inputsHash, imageTag) {
+					logger.Infof("Skipping version %s; build inputs are unchanged and %s is up to date", version.Original(), imageTag)
+					result.Status = statusSkipped
+					return nil
+				}
+			}
+
+			// Verify the source image before building on it
+			if opts.VerifyBase {
+				sourceRef := sourceRepo + ":" + version.Original()
+				logger.Infof("Verifying signature of %s", sourceRef)
+				verifier := baseVerifier{Key: opts.VerifyBaseKey, Identity: opts.VerifyIdentity, Issuer: opts.VerifyIssuer}
+				if err := verifier.verify(ctx, sourceRef); err != nil {
+					return err
+				}
+			}
+
+			if opts.StepTimings {
+				versionBuildOptions.OnStep = func(step docker.BuildStep) {
+					logger.Infof("Step %d took %s: %s", step.Number, step.Duration.Round(time.Millisecond), step.Instruction)
+					result.Steps = append(result.Steps, step)
+				}
+			}
+
+			// buildImage builds the image for the given platform under the given tags, applies the post-build snippet
+			// and checks the result before it's pushed
+			buildImage := func(platform string, buildTags []string) (builtImage, int64, error) {
+				platformBuildOptions := versionBuildOptions
+				platformBuildOptions.Platform = platform
+
+				var imageID, baseID string
+				if opts.InMemoryContext {
+					imageID, baseID, err = client.Images().BuildFiles(ctx, contextFiles, platformBuildOptions, buildTags...)
+				} else {
+					imageID, baseID, err = client.Images().Build(ctx, buildDirectory, platformBuildOptions, buildTags...)
+				}
+				if err != nil {
+					return builtImage{}, 0, fmt.Errorf("build image: %w", err)
+				}
+
+				if imageID == "" || baseID == "" {
+					return builtImage{}, 0, fmt.Errorf("build image: %w", errors.New("image id or base id is empty"))
+				}
+
+				logger.Debugf("Image %s built based on parent image %s", imageID, baseID)
+
+				// Apply the post-build snippet in a second, derived build. The derived image takes over the tags; the
+				// base image stays the same.
+				image := builtImage{ID: imageID, BaseID: baseID}
+				if postBuildSnippet != nil {
+					var derivedID string
+					if opts.InMemoryContext {
+						logger.Infof("Applying post-build snippet to image %s", buildTags[0])
+						files := map[string][]byte{"Dockerfile": postBuildDockerfile(imageID, postBuildSnippet)}
+						derivedID, _, err = client.Images().BuildFiles(ctx, files, platformBuildOptions, buildTags...)
+					} else {
+						name := version.Original() + "-post-build"
+						if len(platforms) > 1 {
+							name = platformTag(name, platform)
+						}
+
+						postBuildDirectory := getTagBuildDir(opts.BuildDir, name)
+						if err = preparePostBuildDirectory(postBuildDirectory, imageID, postBuildSnippet); err != nil {
+							return builtImage{}, 0, fmt.Errorf("create post-build directory: %w", err)
+						}
+
+						if !opts.KeepBuildDirs {
+							pathsToCleanup = append(pathsToCleanup, postBuildDirectory)
+						}
+
+						logger.Infof("Applying post-build snippet to image %s", buildTags[0])
+						derivedID, _, err = client.Images().Build(ctx, postBuildDirectory, platformBuildOptions, buildTags...)
+					}
+					if err != nil {
+						return builtImage{}, 0, fmt.Errorf("build post-build image: %w", err)
+					}
+
+					logger.Debugf("Image %s derived from image %s", derivedID, imageID)
+					if derivedID != imageID {
+						image.ID, image.SnippetBaseID = derivedID, imageID
+						imageID = derivedID
+					}
+				}
+
+				info, err := client.Images().Inspect(ctx, imageID)
+				if err != nil {
+					return builtImage{}, 0, fmt.Errorf("inspect image: %w", err)
+				}
+
+				// Verify the image carries the expected metadata
+				if err = checkImageMetadata(info, opts.AssertLabels, opts.AssertEnv); err != nil {
+					return builtImage{}, 0, fmt.Errorf("verify image %s: %w", buildTags[0], err)
+				}
+
+				// Make sure the image actually works before pushing it
+				if opts.SmokeTest != "" && !opts.DryRun {
+					logger.Infof("Smoke testing image %s", buildTags[0])
+					if err = runSmokeTest(ctx, client.Containers(), imageID, opts.SmokeTest, opts.SmokeTestTimeout); err != nil {
+						return builtImage{}, 0, fmt.Errorf("smoke test image %s: %w", buildTags[0], err)
+					}
+				}
+
+				return image, info.Size, nil
+			}
+
+			// A single platform is built under the final tags. Several platforms are built under a tag of their own
+			// each and pushed as manifest list under the final tags.
+			var built []builtImage
+			var platformTags []string
+			if len(platforms) <= 1 {
+				logger.Infof("Building image %s", imageTag)
+				image, size, err := buildImage(versionBuildOptions.Platform, tags)
+				if err != nil {
+					return err
+				}
+				built, result.Size = append(built, image), size
+			} else {
+				for _, platform := range platforms {
+					tag := platformTag(imageTag, platform)
+					logger.Infof("Building image %s for platform %s", tag, platform)
+					image, size, err := buildImage(platform, []string{tag})
+					if err != nil {
+						return fmt.Errorf("platform %s: %w", platform, err)
+					}
+
+					built, platformTags = append(built, image), append(platformTags, tag)
+					if result.Size == 0 {
+						result.Size = size
+					}
+				}
+			}
+
+			imageID := built[0].ID
+			result.ImageID, result.BaseID = imageID, built[0].BaseID
+
+			// The platform images have to be in the registry before a manifest list can reference them
+			var platformImages []docker.PlatformImage
+			if len(platformTags) > 0 && !opts.DryRun {
+				logger.Infof("Pushing images %s", strings.Join(platformTags, ", "))
+				digests, _, err := pushTags(ctx, client.Images(), platformTags, immutableFail)
+				if err != nil {
+					return fmt.Errorf("push platform image: %w", err)
+				}
+
+				for i, platform := range platforms {
+					platformImages = append(platformImages, docker.PlatformImage{Platform: platform, Digest: digests[platformTags[i]]})
+				}
+			}
+
+			// push pushes the given tags; the image itself for a single platform, a manifest list of the platform
+			// images otherwise
+			push := func(pushTagList []string) (map[string]string, []string, error) {
+				if len(platformImages) == 0 {
+					return pushTags(ctx, client.Images(), pushTagList, opts.OnImmutable)
+				}
+
+				return pushManifestLists(ctx, client.Manifests(), opts.TargetRepo, platformImages, pushTagList, opts.OnImmutable)
+			}
+
+			// Push image to its staging tag first; the final tags are only pushed once that succeeded
+			if opts.StagingSuffix != "" && !opts.DryRun {
+				stagingTag := imageTag + opts.StagingSuffix
+				if len(platformImages) == 0 {
+					err = pushStaging(ctx, client.Images(), imageID, stagingTag, opts.VerifyPush)
+				} else {
+					logger.Infof("Pushing staging image %s", stagingTag)
+					var digests map[string]string
+					if digests, _, err = push([]string{stagingTag}); err == nil && opts.VerifyPush {
+						logger.Infof("Verifying push of staging image %s", stagingTag)
+						err = verifyPush(ctx, client.Images(), []string{stagingTag}, digests)
+					}
+				}
+				if errors.Is(err, docker.ErrTagImmutable) && opts.OnImmutable == immutableSkip {
+					logger.Infof("Skipping version %s; staging tag %s is immutable", version.Original(), stagingTag)
+					result.Status = statusSkipped
+					return nil
+				}
+				if err != nil {
+					return fmt.Errorf("push staging image: %w", err)
+				}
+				logger.Infof("Promoting image %s", stagingTag)
+			}
+
+			// Push image
+			if !opts.DryRun {
+				logger.Infof("Pushing image %s", imageTag)
+				digests, refused, err := push(tags)
+				if err != nil {
+					if errors.Is(err, docker.ErrTagImmutable) {
+						return fmt.Errorf("push image: %w; use --on-immutable to skip or force past existing tags", err)
+					}
+					return fmt.Errorf("push image: %w", err)
+				}
+
+				if len(refused) > 0 && opts.OnImmutable == immutableSkip {
+					logger.Infof("Skipping version %s; tag %s is immutable", version.Original(), refused[0])
+					result.Status = statusSkipped
+					return nil
+				}
+
+				pushedTags := slices.DeleteFunc(slices.Clone(tags), func(tag string) bool { return slices.Contains(refused, tag) })
+				if opts.VerifyPush {
+					logger.Infof("Verifying push of image %s", imageTag)
+					if err = verifyPush(ctx, client.Images(), pushedTags, digests); err != nil {
+						return fmt.Errorf("verify push: %w", err)
+					}
+				}
+
+				numPushed++
+				result.Digest = digests[imageTag]
+				maps.Copy(pushedDigests, digests)
+
+				if inputsHash != "" && result.Digest != "" {
+					if err = records.record(version.Original(), inputsHash, result.Digest); err != nil {
+						logger.Warnf("Failed to save build records: %v", err)
+					}
+				}
+
+				if err = state.complete(version.Original()); err != nil {
+					logger.Warnf("Failed to save run state: %v", err)
+				}
+
+				if opts.PostPushHook != nil {
+					data := hookData{Version: version.Original(), Tag: imageTag, Tags: pushedTags, Digest: result.Digest}
+					if err = runCommandHook(ctx, opts.PostPushHook, data); err != nil {
+						if opts.FailOnHookError {
+							return err
+						}
+						logger.Warnf("Post-push command for image %s failed: %v", imageTag, err)
+					}
+				}
+			} else {
+				logger.Infof("Dry run enabled; skipping push for image %s", imageTag)
+			}
+
+			// Export image
+			if opts.ExportDir != "" {
+				if len(platformTags) == 0 {
+					logger.Infof("Exporting image %s to %s", imageTag, opts.ExportDir)
+					if err = exportImage(ctx, client.Images(), opts.ExportDir, version.Original(), imageTag); err != nil {
+						return fmt.Errorf("export image: %w", err)
+					}
+				}
+
+				for i, tag := range platformTags {
+					logger.Infof("Exporting image %s to %s", tag, opts.ExportDir)
+					if err = exportImage(ctx, client.Images(), opts.ExportDir, platformTag(version.Original(), platforms[i]), tag); err != nil {
+						return fmt.Errorf("export image: %w", err)
+					}
+				}
+			}
+
+			// Clean-up
+
+			// Remove images that exceed the number of images to keep
+			var imagesToRemove []string
+			keptImages = append(keptImages, built...)
+			imagesToRemove, keptImages = evictImages(keptImages, opts.KeepImages, opts.KeepBaseImages)
+
+			if len(imagesToRemove) > 0 {
+				logger.Infof("Removing build artifacts")
+				if err = client.Images().Remove(ctx, imagesToRemove...); err != nil {
+					return fmt.Errorf("remove images: %w", err)
+				}
+			}
+
+			result.Status = statusPushed
+			if opts.DryRun {
+				result.Status = statusBuilt
+			}
+			result.Duration = time.Since(started)
+
+			logger.Infof("Done with image %s", version.Original())
+
+			return nil
+		}()
+		if versionErr == nil {
+			continue
+		}
+
+		// Without fail-fast, a failed version doesn't stop the run; a canceled run always stops immediately
+		if !opts.NoFailFast || ctx.Err() != nil {
+			return versionErr
+		}
+
+		logger.Errorf("Version %s failed: %v", version.Original(), versionErr)
+		result.Status = statusFailed
+		result.Error = versionErr.Error()
+		result.Duration = time.Since(started)
+		failures = append(failures, fmt.Errorf("version %s: %w", version.Original(), versionErr))
+	}
+
+	logger.Debugf("Pushed %d images", numPushed)
+
+	if opts.CompareBases {
+		for _, group := range groupByBase(report.Results) {
+			logger.Infof("Base image %s: %s", shortID(group.BaseID), strings.Join(group.Versions, ", "))
+		}
+	}
+
+	// The run is complete; there's nothing left to resume, unless versions failed
+	if len(failures) == 0 {
+		if err = state.clear(); err != nil {
+			logger.Warnf("Failed to clear run state: %v", err)
+		}
+	}
+
+	// Compare against the previous runs and record what has been pushed in this one
+	if !opts.DryRun {
+		summary, err := loadRunSummary(opts.TargetRepo)
+		if err != nil {
+			return fmt.Errorf("load run summary: %w", err)
+		}
+
+		// The latest tag only moved if it has been set in this run
+		taggedLatest := ""
+		if opts.TagLatest {
+			taggedLatest = latestVersion
+		}
+
+		log := summary.update(report.Results, taggedLatest)
+		changes = &log
+		if err = summary.save(); err != nil {
+			logger.Warnf("Failed to save run summary: %v", err)
+		}
+
+		if opts.ChangelogFile != "" {
+			logger.Infof("Writing changelog to %s", opts.ChangelogFile)
+			if err = os.WriteFile(opts.ChangelogFile, changes.markdown(sourceRepo, opts.TargetRepo, opts.LatestTag), 0o644); err != nil {
+				return fmt.Errorf("write changelog: %w", err)
+			}
+		}
+	}
+
+	// Quiet runs without changes only show warnings and errors, and a single line instead of the summary
+	if opts.SummaryOnChange && len(failures) == 0 && !hasChanges(report.Results) {
+		heldLogsFromContext(ctx).release(zapcore.WarnLevel)
+		fmt.Println("No changes")
+	} else {
+		heldLogsFromContext(ctx).release(zapcore.DebugLevel)
+		printRunSummary(os.Stdout, report.Results, changes, report.IgnoredTags)
+	}
+
+	if len(failures) > 0 {
+		return versionFailuresError(failures, numTags)
+	}
+
+	// Signal runs that had nothing to do, i.e. pushed, or in dry run mode built, no image
+	if !slices.ContainsFunc(report.Results, func(result *versionResult) bool {
+		return result.Status == statusPushed || result.Status == statusBuilt
+	}) {
+		if opts.FailOnEmptyPush && !opts.DryRun {
+			return ErrNothingPushed
+		}
 
-		logger.Infof("Done with image %s", version.Original())
+		return ErrNothingToDo
 	}
 
 	return nil
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/nikoksr/mimikry/pkg/docker"
+)
+
+func TestPrepareBuildDirectoryRendersAdditionalFiles(t *testing.T) {
+	t.Parallel()
+
+	templates := parseTestTemplates(t, map[string]string{
+		"Dockerfile": "FROM postgres:{{ .Version }}\nCOPY config.env /etc/postgres/\n",
+		"config.env": "PG_VERSION={{ .Version }}\n",
+	})
+
+	dir := t.TempDir()
+	if err := prepareBuildDirectory(dir, semver.MustParse("16.3"), templates, &options{}); err != nil {
+		t.Fatalf("prepareBuildDirectory() error = %v", err)
+	}
+
+	want := map[string]string{
+		"Dockerfile": "FROM postgres:16.3\nCOPY config.env /etc/postgres/\n",
+		"config.env": "PG_VERSION=16.3\n",
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+}
+
+func TestPrepareBuildDirectoryLeavesNoPartialFile(t *testing.T) {
+	t.Parallel()
+
+	templates := parseTestTemplates(t, map[string]string{
+		"Dockerfile": "FROM postgres:{{ .Version }}\n",
+		"config.env": "PG_VERSION={{ .Version }}\n{{ template \"missing\" . }}\n",
+	})
+
+	// A file rendered by a previous run gets removed as well
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.env"), []byte("PG_VERSION=16.2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := prepareBuildDirectory(dir, semver.MustParse("16.3"), templates, &options{})
+	if err == nil {
+		t.Fatal("prepareBuildDirectory() error = nil, want error")
+	}
+
+	if !strings.Contains(err.Error(), `template "config.env"`) {
+		t.Errorf("prepareBuildDirectory() error = %q, want it to name the template", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, "config.env")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("config.env left behind: %v", err)
+	}
+
+	leftovers, err := filepath.Glob(filepath.Join(dir, "*config.env*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftovers) != 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
+
+// fakeDaemon is a docker daemon that accepts classic builds and keeps the build context of the last one.
+type fakeDaemon struct {
+	mu           sync.Mutex
+	buildContext map[string]string // File contents keyed by their path in the build context
+}
+
+func newFakeDaemon(t *testing.T) (*fakeDaemon, string) {
+	t.Helper()
+
+	daemon := &fakeDaemon{}
+	server := httptest.NewServer(http.HandlerFunc(daemon.serve))
+	t.Cleanup(server.Close)
+
+	return daemon, "tcp://" + strings.TrimPrefix(server.URL, "http://")
+}
+
+func (d *fakeDaemon) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Api-Version", "1.41")
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/_ping"):
+		w.Header().Set("Builder-Version", "1")
+	case strings.HasSuffix(r.URL.Path, "/build"):
+		files := make(map[string]string)
+		archive := tar.NewReader(r.Body)
+		for {
+			header, err := archive.Next()
+			if err != nil {
+				break
+			}
+
+			content, _ := io.ReadAll(archive)
+			files[header.Name] = string(content)
+		}
+
+		d.mu.Lock()
+		d.buildContext = files
+		d.mu.Unlock()
+
+		fmt.Fprintln(w, `{"stream":"Successfully built 1234"}`)
+	case strings.HasSuffix(r.URL.Path, "/images/json"):
+		fmt.Fprint(w, `[{"Id":"sha256:1234"}]`)
+	case strings.HasSuffix(r.URL.Path, "/history"):
+		fmt.Fprint(w, `[{"Id":"sha256:1234"},{"Id":"<missing>"},{"Id":"sha256:base"}]`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestPrepareBuildContextUsesBuildDir(t *testing.T) {
+	t.Parallel()
+
+	templates := parseTestTemplates(t, map[string]string{
+		"Dockerfile": "FROM postgres:{{ .Version }}\n",
+		"config.env": "PG_VERSION={{ .Version }}\n",
+	})
+
+	buildDir := filepath.Join(t.TempDir(), "custom-build")
+	version := semver.MustParse("16.3")
+
+	dir, files, err := prepareBuildContext(version, templates, &options{BuildDir: buildDir})
+	if err != nil {
+		t.Fatalf("prepareBuildContext() error = %v", err)
+	}
+
+	if want := filepath.Join(buildDir, "16.3"); dir != want {
+		t.Errorf("prepareBuildContext() dir = %q, want %q", dir, want)
+	}
+
+	if files != nil {
+		t.Errorf("prepareBuildContext() files = %q, want none", files)
+	}
+
+	// Build from the returned directory, as the main loop does, and check what the daemon got as build context
+	daemon, host := newFakeDaemon(t)
+	ctx := context.Background()
+	client, err := docker.New(ctx, docker.WithHost(host), docker.WithBuildBackend(docker.BuildBackendClassic))
+	if err != nil {
+		t.Fatalf("docker.New() error = %v", err)
+	}
+
+	if _, _, err = client.Images().Build(ctx, dir, docker.BuildOptions{}, "me/repo:16.3"); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	want := map[string]string{
+		"Dockerfile": "FROM postgres:16.3\n",
+		"config.env": "PG_VERSION=16.3\n",
+	}
+	if !reflect.DeepEqual(daemon.buildContext, want) {
+		t.Errorf("build context = %q, want %q", daemon.buildContext, want)
+	}
+}
+
+func TestParsePlatforms(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		spec    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "single", spec: "linux/arm64", want: []string{"linux/arm64"}},
+		{name: "variant", spec: "linux/arm/v7", want: []string{"linux/arm/v7"}},
+		{name: "several", spec: "linux/amd64, linux/arm64", want: []string{"linux/amd64", "linux/arm64"}},
+		{name: "missing arch", spec: "linux", wantErr: true},
+		{name: "empty entry", spec: "linux/amd64,", wantErr: true},
+		{name: "too many parts", spec: "linux/arm/v7/x", wantErr: true},
+		{name: "duplicate", spec: "linux/amd64,linux/amd64", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parsePlatforms(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePlatforms(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePlatforms(%q) = %q, want %q", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlatformsFor(t *testing.T) {
+	t.Parallel()
+
+	mappings, err := parseVersionMappings([]string{">=12:linux/amd64,linux/arm64", "<10:linux/386"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &options{Platforms: []string{"linux/amd64"}, PlatformMappings: mappings}
+
+	tests := []struct {
+		version string
+		want    []string
+	}{
+		{version: "16.3", want: []string{"linux/amd64", "linux/arm64"}},
+		{version: "12.0", want: []string{"linux/amd64", "linux/arm64"}},
+		{version: "9.6", want: []string{"linux/386"}},
+		{version: "11.2", want: []string{"linux/amd64"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.version, func(t *testing.T) {
+			t.Parallel()
+
+			if got := platformsFor(opts, semver.MustParse(tt.version)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("platformsFor(%s) = %q, want %q", tt.version, got, tt.want)
+			}
+		})
+	}
+
+	if got := platformsFor(&options{}, semver.MustParse("16.3")); len(got) != 0 {
+		t.Errorf("platformsFor() without platforms = %q, want none", got)
+	}
+}
+
+func TestRequestedPlatforms(t *testing.T) {
+	t.Parallel()
+
+	mappings, err := parseVersionMappings([]string{">=12:linux/amd64,linux/arm64", "<10:linux/386,linux/arm64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &options{Platforms: []string{"linux/amd64"}, PlatformMappings: mappings}
+	want := []string{"linux/amd64", "linux/arm64", "linux/386"}
+	if got := requestedPlatforms(opts); !reflect.DeepEqual(got, want) {
+		t.Errorf("requestedPlatforms() = %q, want %q", got, want)
+	}
+
+	if got := requestedPlatforms(&options{}); len(got) != 0 {
+		t.Errorf("requestedPlatforms() without platforms = %q, want none", got)
+	}
+}
+
+func TestPlatformTag(t *testing.T) {
+	t.Parallel()
+
+	if got, want := platformTag("me/repo:16.3", "linux/arm/v7"), "me/repo:16.3-linux-arm-v7"; got != want {
+		t.Errorf("platformTag() = %q, want %q", got, want)
+	}
+}
+
+func TestEvictImages(t *testing.T) {
+	t.Parallel()
+
+	images := []builtImage{
+		{ID: "sha256:a", BaseID: "sha256:base1", SnippetBaseID: "sha256:a-pre"},
+		{ID: "sha256:b", BaseID: "sha256:base1"},
+		{ID: "sha256:c", BaseID: "sha256:base2"},
+	}
+
+	tests := []struct {
+		name      string
+		keep      int
+		keepBases bool
+		wantIDs   []string
+		wantKept  []builtImage
+	}{
+		{name: "keep all", keep: 3, wantKept: images},
+		{name: "keep one", keep: 1, wantIDs: []string{"sha256:a", "sha256:a-pre", "sha256:base1", "sha256:b"}, wantKept: images[2:]},
+		{name: "keep bases", keep: 1, keepBases: true, wantIDs: []string{"sha256:a", "sha256:a-pre", "sha256:b"}, wantKept: images[2:]},
+		{name: "base still used", keep: 2, wantIDs: []string{"sha256:a", "sha256:a-pre"}, wantKept: images[1:]},
+		{name: "keep none", keep: 0, wantIDs: []string{"sha256:a", "sha256:a-pre", "sha256:base1", "sha256:b", "sha256:c", "sha256:base2"}, wantKept: []builtImage{}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ids, kept := evictImages(slices.Clone(images), tt.keep, tt.keepBases)
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("evictImages() removed %v, want %v", ids, tt.wantIDs)
+			}
+
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Errorf("evictImages() kept %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// versionMapping maps a range of versions, given as semver constraint, to a value; e.g. a template name.
+type versionMapping struct {
+	Constraint *semver.Constraints
+	Value      string
+}
+
+// parseVersionMappings parses a list of CONSTRAINT:VALUE pairs, like ">=14:Dockerfile.new".
+func parseVersionMappings(specs []string) ([]versionMapping, error) {
+	mappings := make([]versionMapping, 0, len(specs))
+	for _, spec := range specs {
+		rawConstraint, value, ok := strings.Cut(spec, ":")
+		value = strings.TrimSpace(value)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid version mapping %q; expected CONSTRAINT:VALUE", spec)
+		}
+
+		constraint, err := semver.NewConstraint(rawConstraint)
+		if err != nil {
+			return nil, fmt.Errorf("parse constraint of version mapping %q: %w", spec, err)
+		}
+
+		mappings = append(mappings, versionMapping{Constraint: constraint, Value: value})
+	}
+
+	return mappings, nil
+}
+
+// matchVersionMapping returns the value of the first mapping whose constraint matches the given version.
+func matchVersionMapping(mappings []versionMapping, version *semver.Version) (string, bool) {
+	for _, mapping := range mappings {
+		if mapping.Constraint.Check(version) {
+			return mapping.Value, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+const (
+	presetLatestMajor     = "latest-major"
+	presetSupported       = "supported"
+	presetLastThreeMinors = "last-3-minors"
+)
+
+var versionPresets = []string{presetLatestMajor, presetSupported, presetLastThreeMinors}
+
+// applyVersionPreset filters the given, ascending sorted versions according to a named preset.
+func applyVersionPreset(ctx context.Context, preset, sourceRepo string, versions []*semver.Version) ([]*semver.Version, error) {
+	switch preset {
+	case presetLatestMajor:
+		if len(versions) == 0 {
+			return versions, nil
+		}
+
+		latestMajor := versions[len(versions)-1].Major()
+		filtered := make([]*semver.Version, 0, len(versions))
+		for _, version := range versions {
+			if version.Major() == latestMajor {
+				filtered = append(filtered, version)
+			}
+		}
+
+		return filtered, nil
+	case presetLastThreeMinors:
+		return lastMinors(versions, 3), nil
+	case presetSupported:
+		return filterEOLVersions(ctx, newEndOfLifeDateProvider(), sourceRepo, versions)
+	default:
+		return nil, fmt.Errorf("unknown version preset %q", preset)
+	}
+}
+
+// lastMinors returns the versions of the n newest major.minor release lines from the given, ascending sorted
+// versions.
+func lastMinors(versions []*semver.Version, n int) []*semver.Version {
+	minors := 0
+	start := len(versions)
+	for idx := len(versions) - 1; idx >= 0; idx-- {
+		if idx == len(versions)-1 || !sameMinor(versions[idx], versions[idx+1]) {
+			minors++
+			if minors > n {
+				break
+			}
+		}
+
+		start = idx
+	}
+
+	return versions[start:]
+}
+
+func sameMinor(a, b *semver.Version) bool {
+	return a.Major() == b.Major() && a.Minor() == b.Minor()
+}
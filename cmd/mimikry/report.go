@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nikoksr/mimikry/pkg/docker"
+)
+
+type (
+	// versionResult is the outcome of processing a single version.
+	versionResult struct {
+		Version  string
+		Tags     []string
+		ImageID  string
+		BaseID   string
+		Digest   string
+		Size     int64
+		Status   string
+		Error    string
+		Duration time.Duration
+		Steps    []docker.BuildStep // Only recorded with --step-timings
+	}
+
+	// runReport is the data passed to the report templates.
+	runReport struct {
+		TargetRepo  string
+		SourceRepo  string
+		Version     string
+		Started     time.Time
+		Duration    time.Duration
+		Error       string
+		Results     []*versionResult
+		IgnoredTags []ignoredTag // Source tags that couldn't be parsed as versions
+	}
+)
+
+const (
+	statusPushed  = "pushed"
+	statusBuilt   = "built"
+	statusSkipped = "skipped"
+	statusFailed  = "failed"
+
+	reportFormatHTML = "html"
+)
+
+var (
+	//go:embed report.html.tmpl
+	rawHTMLReportTemplate string
+
+	htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+		"humanSize":  humanSize,
+		"shortID":    shortID,
+		"roundTime":  func(d time.Duration) time.Duration { return d.Round(time.Second) },
+		"roundStep":  func(d time.Duration) time.Duration { return d.Round(100 * time.Millisecond) },
+		"formatTime": func(t time.Time) string { return t.Format(time.RFC1123) },
+	}).Parse(rawHTMLReportTemplate))
+)
+
+func humanSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "kMGTPE"[exp])
+}
+
+// shortID returns the short form of an image ID as shown by the docker CLI, i.e. the first 12 hex digits.
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+
+	return id
+}
+
+// writeReport renders the run report in the given format and writes it to path.
+func writeReport(format, path string, report *runReport) error {
+	if format != reportFormatHTML {
+		return fmt.Errorf("unsupported report format %q", format)
+	}
+
+	var buf bytes.Buffer
+	if err := htmlReportTemplate.Execute(&buf, report); err != nil {
+		return fmt.Errorf("render report: %w", err)
+	}
+
+	return writeFileAtomic(path, buf.Bytes(), 0o600)
+}
+
+// baseGroup is a set of versions whose images were built on the same base image.
+type baseGroup struct {
+	BaseID   string
+	Versions []string
+}
+
+// groupByBase groups the built versions by their base image, in the order the base images were first seen. Versions
+// without a known base image, e.g. skipped ones, are left out.
+func groupByBase(results []*versionResult) []baseGroup {
+	var groups []baseGroup
+	index := make(map[string]int)
+	for _, result := range results {
+		if result.BaseID == "" || (result.Status != statusPushed && result.Status != statusBuilt) {
+			continue
+		}
+
+		idx, ok := index[result.BaseID]
+		if !ok {
+			idx = len(groups)
+			index[result.BaseID] = idx
+			groups = append(groups, baseGroup{BaseID: result.BaseID})
+		}
+
+		groups[idx].Versions = append(groups[idx].Versions, result.Version)
+	}
+
+	return groups
+}
+
+// hasChanges reports whether any version was built or pushed during the run.
+func hasChanges(results []*versionResult) bool {
+	return slices.ContainsFunc(results, func(result *versionResult) bool {
+		return result.Status == statusPushed || result.Status == statusBuilt
+	})
+}
+
+// printRunSummary writes a summary of the run to w: the number of versions per status, followed by every version that
+// was built, pushed or failed, and the source tags that were ignored as they couldn't be parsed. If changes is non-nil,
+// pushed versions are split into new and rebuilt ones.
+func printRunSummary(w io.Writer, results []*versionResult, changes *changelog, ignored []ignoredTag) {
+	counts := make(map[string]int)
+	for _, result := range results {
+		counts[result.Status]++
+	}
+
+	_, _ = fmt.Fprintf(w, "Summary: %d pushed, %d built, %d skipped, %d failed\n",
+		counts[statusPushed], counts[statusBuilt], counts[statusSkipped], counts[statusFailed])
+
+	if changes != nil && counts[statusPushed] > 0 {
+		_, _ = fmt.Fprintf(w, "  %d new, %d rebuilt\n", len(changes.Added), len(changes.Updated))
+	}
+
+	for _, result := range results {
+		switch result.Status {
+		case statusPushed, statusBuilt:
+			_, _ = fmt.Fprintf(w, "  %-8s %s (%s)\n", result.Status, result.Version, strings.Join(result.Tags, ", "))
+		case statusFailed:
+			_, _ = fmt.Fprintf(w, "  %-8s %s: %s\n", result.Status, result.Version, result.Error)
+		}
+	}
+
+	if len(ignored) > 0 {
+		_, _ = fmt.Fprintf(w, "Ignored %d source tags that could not be parsed as versions:\n", len(ignored))
+		for _, tag := range ignored {
+			_, _ = fmt.Fprintf(w, "  %s: %s\n", tag.Tag, tag.Reason)
+		}
+	}
+}
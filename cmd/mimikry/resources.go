@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+// buildResources are the resource limits of the build containers.
+type buildResources struct {
+	Memory  int64
+	CPUs    float64
+	Ulimits []*container.Ulimit
+}
+
+// parseBuildResources parses the resource limits given on the command line; a memory size like "4g", a number of CPUs
+// and ulimits like "nofile=1024:2048".
+func parseBuildResources(memory string, cpus float64, ulimitSpecs []string) (buildResources, error) {
+	var resources buildResources
+
+	if memory != "" {
+		bytes, err := units.RAMInBytes(memory)
+		if err != nil {
+			return buildResources{}, fmt.Errorf("parse build memory: %w", err)
+		}
+
+		if bytes <= 0 {
+			return buildResources{}, fmt.Errorf("invalid build memory %q; must be positive", memory)
+		}
+
+		resources.Memory = bytes
+	}
+
+	if cpus < 0 {
+		return buildResources{}, errors.New("--build-cpus must not be negative")
+	}
+	resources.CPUs = cpus
+
+	for _, spec := range ulimitSpecs {
+		ulimit, err := units.ParseUlimit(spec)
+		if err != nil {
+			return buildResources{}, fmt.Errorf("parse build ulimit: %w", err)
+		}
+
+		resources.Ulimits = append(resources.Ulimits, ulimit)
+	}
+
+	return resources, nil
+}
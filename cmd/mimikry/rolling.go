@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// Granularities of rolling tags; the number of version components in the tag.
+const (
+	rollingMajor = 1 // e.g. 16, pointing at the newest 16.x
+	rollingMinor = 2 // e.g. 16.1, pointing at the newest 16.1.x
+)
+
+// rollingTags returns the rolling tags of the given granularities, keyed by the version that receives them: the newest
+// version of each major or minor version with more components than the tag, e.g. 16.1.3 receives 16.1 and, if it's
+// the newest 16.x, 16. Versions the source repository tags with fewer components get no rolling tag, as that tag is
+// built from the source image of the same name already.
+func rollingTags(versions []*semver.Version, granularities ...int) map[string][]string {
+	tags := make(map[string][]string)
+	for _, granularity := range granularities {
+		newest := make(map[string]*semver.Version)
+		ownTag := make(map[string]bool)
+
+		for _, version := range versions {
+			key := fmt.Sprintf("%d", version.Major())
+			if granularity == rollingMinor {
+				key = fmt.Sprintf("%d.%d", version.Major(), version.Minor())
+			}
+
+			// Only tags with more components than the rolling tag take part; e.g. 16.1 for 16, but not 16 itself
+			if strings.Count(version.Original(), ".") < granularity {
+				if version.Original() == key {
+					ownTag[key] = true
+				}
+				continue
+			}
+
+			if current, ok := newest[key]; !ok || version.GreaterThan(current) {
+				newest[key] = version
+			}
+		}
+
+		for key, version := range newest {
+			if ownTag[key] {
+				continue
+			}
+
+			tags[version.Original()] = append(tags[version.Original()], key)
+		}
+	}
+
+	return tags
+}
+
+// rollingGranularities returns the granularities of the rolling tags requested by the given options.
+func rollingGranularities(opts *options) []int {
+	var granularities []int
+	if opts.RollingMajor {
+		granularities = append(granularities, rollingMajor)
+	}
+
+	if opts.RollingMinor {
+		granularities = append(granularities, rollingMinor)
+	}
+
+	return granularities
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/nikoksr/mimikry/pkg/docker"
+)
+
+func parseVersions(t *testing.T, raw ...string) []*semver.Version {
+	t.Helper()
+
+	versions := make([]*semver.Version, 0, len(raw))
+	for _, r := range raw {
+		version, err := semver.NewVersion(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, version)
+	}
+
+	return versions
+}
+
+func TestRollingTags(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		versions      []string
+		granularities []int
+		want          map[string][]string
+	}{
+		{
+			name:          "major",
+			versions:      []string{"15.6", "15.7", "16.2", "16.3"},
+			granularities: []int{rollingMajor},
+			want:          map[string][]string{"15.7": {"15"}, "16.3": {"16"}},
+		},
+		{
+			name:          "minor",
+			versions:      []string{"16.1.2", "16.1.3", "16.2.0"},
+			granularities: []int{rollingMinor},
+			want:          map[string][]string{"16.1.3": {"16.1"}, "16.2.0": {"16.2"}},
+		},
+		{
+			name:          "major and minor",
+			versions:      []string{"16.1.2", "16.1.3", "16.2.0"},
+			granularities: []int{rollingMajor, rollingMinor},
+			want:          map[string][]string{"16.1.3": {"16.1"}, "16.2.0": {"16", "16.2"}},
+		},
+		{
+			name:          "source tags the major itself",
+			versions:      []string{"16", "16.2", "16.3", "15.7"},
+			granularities: []int{rollingMajor},
+			want:          map[string][]string{"15.7": {"15"}},
+		},
+		{
+			name:          "versions without minor",
+			versions:      []string{"15", "16"},
+			granularities: []int{rollingMajor, rollingMinor},
+			want:          map[string][]string{},
+		},
+		{
+			name:          "no granularities",
+			versions:      []string{"16.2", "16.3"},
+			granularities: nil,
+			want:          map[string][]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := rollingTags(parseVersions(t, tt.versions...), tt.granularities...)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rollingTags(%v) = %v, want %v", tt.versions, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRollingGranularities(t *testing.T) {
+	t.Parallel()
+
+	got := rollingGranularities(&options{RollingMajor: true, RollingMinor: true})
+	if want := []int{rollingMajor, rollingMinor}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rollingGranularities() = %v, want %v", got, want)
+	}
+
+	if got = rollingGranularities(&options{}); len(got) != 0 {
+		t.Errorf("rollingGranularities() without rolling tags = %v, want none", got)
+	}
+}
+
+// recordingManifests records the manifest lists pushed through it.
+type recordingManifests struct {
+	pushed map[string][]docker.PlatformImage
+}
+
+func (m *recordingManifests) PushManifestList(_ context.Context, repo string, images []docker.PlatformImage, tags ...string) (string, error) {
+	for _, tag := range tags {
+		m.pushed[repo+":"+tag] = images
+	}
+
+	return "sha256:list", nil
+}
+
+func TestRollingTagsPushedAsManifestList(t *testing.T) {
+	t.Parallel()
+
+	versions := parseVersions(t, "16.2", "16.3")
+	rolling := rollingTags(versions, rollingMajor)
+	tags := imageTagsFor("me/repo", "16.3", rolling["16.3"], "")
+
+	images := []docker.PlatformImage{
+		{Platform: "linux/amd64", Digest: "sha256:amd64"},
+		{Platform: "linux/arm64", Digest: "sha256:arm64"},
+	}
+	manifests := &recordingManifests{pushed: make(map[string][]docker.PlatformImage)}
+
+	digests, refused, err := pushManifestLists(context.Background(), manifests, "me/repo", images, tags, immutableFail)
+	if err != nil {
+		t.Fatalf("pushManifestLists() error = %v", err)
+	}
+
+	if len(refused) != 0 {
+		t.Errorf("pushManifestLists() refused %v", refused)
+	}
+
+	for _, tag := range []string{"me/repo:16.3", "me/repo:16"} {
+		if !reflect.DeepEqual(manifests.pushed[tag], images) {
+			t.Errorf("manifest list of %s = %v, want %v", tag, manifests.pushed[tag], images)
+		}
+
+		if digests[tag] != "sha256:list" {
+			t.Errorf("digest of %s = %q, want %q", tag, digests[tag], "sha256:list")
+		}
+	}
+}
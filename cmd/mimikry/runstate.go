@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// runState tracks which versions of a run have been completed, i.e. built and pushed, so an interrupted run can be
+// resumed. A state is bound to a target repository and the exact set of templates.
+type runState struct {
+	TargetRepo   string    `json:"target_repo"`
+	TemplateHash string    `json:"template_hash"`
+	Modified     time.Time `json:"modified"`
+	Completed    []string  `json:"completed"`
+
+	path string
+}
+
+// hashTemplates returns a hash over the names and contents of all files in the given template directory and its
+// partials directory.
+func hashTemplates(dir string) (string, error) {
+	hash := sha256.New()
+	if err := hashTemplateFiles(hash, dir, ""); err != nil {
+		return "", err
+	}
+
+	if err := hashTemplateFiles(hash, filepath.Join(dir, partialsDirectory), partialsDirectory+"/"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashDockerfileTemplate returns a hash over the content of a single Dockerfile template, as given by --dockerfile.
+func hashDockerfileTemplate(content []byte) string {
+	hash := sha256.New()
+	_, _ = fmt.Fprintf(hash, "%s\x00%d\x00", "Dockerfile", len(content))
+	_, _ = hash.Write(content)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// hashTemplateFiles writes the names, prefixed with the given prefix, and contents of all files in dir to the hash.
+func hashTemplateFiles(hash io.Writer, dir, prefix string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("read template directory: %w", err)
+	}
+
+	for _, entry := range entries { // ReadDir returns entries sorted by name
+		if entry.IsDir() {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("read template: %w", err)
+		}
+
+		_, _ = fmt.Fprintf(hash, "%s\x00%d\x00", prefix+entry.Name(), len(content))
+		_, _ = hash.Write(content)
+	}
+
+	return nil
+}
+
+func runStatePath(targetRepo, templateHash string) string {
+	key := sha256.Sum256([]byte(targetRepo + "\x00" + templateHash))
+
+	return filepath.FromSlash(filepath.Join(runStateDirectory, hex.EncodeToString(key[:8])+".json"))
+}
+
+// loadRunState loads the run state for the given target repository and template hash. If no state exists yet, an
+// empty one is returned.
+func loadRunState(targetRepo, templateHash string) (*runState, error) {
+	state := &runState{
+		TargetRepo:   targetRepo,
+		TemplateHash: templateHash,
+		path:         runStatePath(targetRepo, templateHash),
+	}
+
+	content, err := os.ReadFile(state.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return state, nil
+		}
+
+		return nil, fmt.Errorf("read run state: %w", err)
+	}
+
+	if err = json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("decode run state: %w", err)
+	}
+
+	// Guard against hash collisions of the file name
+	if state.TargetRepo != targetRepo || state.TemplateHash != templateHash {
+		state.Completed = nil
+	}
+
+	return state, nil
+}
+
+func (s *runState) isCompleted(version string) bool {
+	return slices.Contains(s.Completed, version)
+}
+
+// complete marks the given version as completed and persists the state.
+func (s *runState) complete(version string) error {
+	if !s.isCompleted(version) {
+		s.Completed = append(s.Completed, version)
+	}
+	s.Modified = time.Now()
+
+	content, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("encode run state: %w", err)
+	}
+
+	return writeFileAtomic(s.path, content, 0o600)
+}
+
+// clear removes the persisted state; used once a run finished successfully.
+func (s *runState) clear() error {
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove run state: %w", err)
+	}
+
+	return nil
+}
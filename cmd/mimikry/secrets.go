@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nikoksr/mimikry/pkg/docker"
+)
+
+// parseBuildSecrets parses secrets given on the command line in the format of docker build's --secret flag; e.g.
+// "id=npmrc,src=$HOME/.npmrc" or "id=token,env=GITHUB_TOKEN".
+func parseBuildSecrets(specs []string) ([]docker.BuildSecret, error) {
+	secrets := make([]docker.BuildSecret, 0, len(specs))
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		var secret docker.BuildSecret
+		typ := ""
+		for _, field := range strings.Split(spec, ",") {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid secret %q; expected key=value pairs", spec)
+			}
+
+			switch strings.ToLower(strings.TrimSpace(key)) {
+			case "id":
+				secret.ID = value
+			case "src", "source":
+				secret.Src = value
+			case "env":
+				secret.Env = value
+			case "type":
+				typ = value
+			default:
+				return nil, fmt.Errorf("invalid secret %q; unknown key %q", spec, key)
+			}
+		}
+
+		if secret.ID == "" {
+			return nil, fmt.Errorf("invalid secret %q; missing id", spec)
+		}
+
+		if seen[secret.ID] {
+			return nil, fmt.Errorf("duplicate secret id %q", secret.ID)
+		}
+		seen[secret.ID] = true
+
+		switch typ {
+		case "", "file":
+			// Without a source, the secret is read from the environment variable or file named like its id
+		case "env":
+			// A plain source names the environment variable for secrets of this type
+			if secret.Env == "" {
+				secret.Env, secret.Src = secret.Src, ""
+			}
+		default:
+			return nil, fmt.Errorf("invalid secret %q; unknown type %q", spec, typ)
+		}
+
+		if secret.Src != "" && secret.Env != "" {
+			return nil, fmt.Errorf("invalid secret %q; src and env are mutually exclusive", spec)
+		}
+
+		secrets = append(secrets, secret)
+	}
+
+	return secrets, nil
+}
+
+// parseBuildSSH parses SSH agents given on the command line in the format of docker build's --ssh flag; e.g.
+// "default" for the agent of SSH_AUTH_SOCK, or "github=$HOME/.ssh/id_ed25519" for a key or agent socket.
+func parseBuildSSH(specs []string) ([]docker.BuildSSH, error) {
+	agents := make([]docker.BuildSSH, 0, len(specs))
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		id, paths, _ := strings.Cut(spec, "=")
+		if id == "" {
+			return nil, fmt.Errorf("invalid ssh agent %q; missing id", spec)
+		}
+
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate ssh agent id %q", id)
+		}
+		seen[id] = true
+
+		agent := docker.BuildSSH{ID: id}
+		if paths != "" {
+			agent.Paths = strings.Split(paths, ",")
+		}
+
+		agents = append(agents, agent)
+	}
+
+	return agents, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nikoksr/mimikry/pkg/docker"
+)
+
+func TestParseBuildSecrets(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		specs   []string
+		want    []docker.BuildSecret
+		wantErr bool
+	}{
+		{name: "file", specs: []string{"id=npmrc,src=.npmrc"}, want: []docker.BuildSecret{{ID: "npmrc", Src: ".npmrc"}}},
+		{name: "source alias", specs: []string{"id=npmrc,source=.npmrc"}, want: []docker.BuildSecret{{ID: "npmrc", Src: ".npmrc"}}},
+		{name: "env", specs: []string{"id=token,env=GITHUB_TOKEN"}, want: []docker.BuildSecret{{ID: "token", Env: "GITHUB_TOKEN"}}},
+		{name: "env type", specs: []string{"type=env,id=token,src=GITHUB_TOKEN"}, want: []docker.BuildSecret{{ID: "token", Env: "GITHUB_TOKEN"}}},
+		{name: "id only", specs: []string{"id=token"}, want: []docker.BuildSecret{{ID: "token"}}},
+		{name: "several", specs: []string{"id=a,src=a.txt", "id=b,env=B"}, want: []docker.BuildSecret{{ID: "a", Src: "a.txt"}, {ID: "b", Env: "B"}}},
+		{name: "missing id", specs: []string{"src=.npmrc"}, wantErr: true},
+		{name: "duplicate id", specs: []string{"id=a,src=a.txt", "id=a,env=A"}, wantErr: true},
+		{name: "unknown key", specs: []string{"id=a,path=a.txt"}, wantErr: true},
+		{name: "unknown type", specs: []string{"id=a,type=vault"}, wantErr: true},
+		{name: "src and env", specs: []string{"id=a,src=a.txt,env=A"}, wantErr: true},
+		{name: "no pairs", specs: []string{"npmrc"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseBuildSecrets(tt.specs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBuildSecrets(%q) error = %v, wantErr %v", tt.specs, err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBuildSecrets(%q) = %+v, want %+v", tt.specs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBuildSSH(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		specs   []string
+		want    []docker.BuildSSH
+		wantErr bool
+	}{
+		{name: "default agent", specs: []string{"default"}, want: []docker.BuildSSH{{ID: "default"}}},
+		{name: "socket", specs: []string{"default=/run/ssh-agent.sock"}, want: []docker.BuildSSH{{ID: "default", Paths: []string{"/run/ssh-agent.sock"}}}},
+		{name: "keys", specs: []string{"github=id_a,id_b"}, want: []docker.BuildSSH{{ID: "github", Paths: []string{"id_a", "id_b"}}}},
+		{name: "missing id", specs: []string{"=id_a"}, wantErr: true},
+		{name: "duplicate id", specs: []string{"default", "default=id_a"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseBuildSSH(tt.specs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBuildSSH(%q) error = %v, wantErr %v", tt.specs, err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBuildSSH(%q) = %+v, want %+v", tt.specs, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// envSlackWebhook is the environment variable the Slack webhook URL is read from if --slack-webhook isn't set; webhook
+// URLs are secrets and shouldn't end up in the process list.
+const envSlackWebhook = "MIMIKRY_SLACK_WEBHOOK"
+
+// maxSlackSectionLength is the maximum length of a Slack section text; longer texts are truncated.
+const maxSlackSectionLength = 3000
+
+type (
+	// slackMessage is an incoming webhook payload in Slack's block format. Text is the fallback shown in
+	// notifications.
+	slackMessage struct {
+		Text   string       `json:"text"`
+		Blocks []slackBlock `json:"blocks"`
+	}
+
+	slackBlock struct {
+		Type     string      `json:"type"`
+		Text     *slackText  `json:"text,omitempty"`
+		Elements []slackText `json:"elements,omitempty"`
+	}
+
+	slackText struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+)
+
+// newSlackMessage formats the given report as Slack message: the outcome of the run, the new and rebuilt versions, and
+// the failed versions with their errors. If changes is nil, pushed versions are listed without telling new and rebuilt
+// ones apart.
+func newSlackMessage(report *runReport, changes *changelog) slackMessage {
+	counts := make(map[string]int)
+	for _, result := range report.Results {
+		counts[result.Status]++
+	}
+
+	outcome := "succeeded"
+	if report.Error != "" || counts[statusFailed] > 0 {
+		outcome = "failed"
+	}
+
+	title := fmt.Sprintf("mimikry run for %s %s", report.TargetRepo, outcome)
+	summary := fmt.Sprintf("*%d* pushed, *%d* built, *%d* skipped, *%d* failed in %s",
+		counts[statusPushed], counts[statusBuilt], counts[statusSkipped], counts[statusFailed],
+		report.Duration.Round(time.Second))
+
+	message := slackMessage{
+		Text: title,
+		Blocks: []slackBlock{
+			{Type: "header", Text: &slackText{Type: "plain_text", Text: title}},
+			slackSection(summary),
+		},
+	}
+
+	var changed []string
+	if changes != nil {
+		for _, version := range changes.Added {
+			changed = append(changed, fmt.Sprintf("• Added `%s`", version))
+		}
+
+		for _, version := range changes.Updated {
+			changed = append(changed, fmt.Sprintf("• Rebuilt `%s`", version))
+		}
+
+		if changes.Latest != "" {
+			changed = append(changed, fmt.Sprintf("• Latest is now `%s`", changes.Latest))
+		}
+	} else {
+		for _, result := range report.Results {
+			if result.Status == statusPushed || result.Status == statusBuilt {
+				changed = append(changed, fmt.Sprintf("• `%s` (%s)", result.Version, result.Status))
+			}
+		}
+	}
+
+	if len(changed) > 0 {
+		message.Blocks = append(message.Blocks, slackSection(strings.Join(changed, "\n")))
+	}
+
+	var failed []string
+	for _, result := range report.Results {
+		if result.Status == statusFailed {
+			failed = append(failed, fmt.Sprintf("• `%s`: %s", result.Version, result.Error))
+		}
+	}
+
+	switch {
+	case len(failed) > 0:
+		message.Blocks = append(message.Blocks, slackSection("*Failures*\n"+strings.Join(failed, "\n")))
+	case report.Error != "":
+		message.Blocks = append(message.Blocks, slackSection("*Error*\n"+report.Error))
+	}
+
+	message.Blocks = append(message.Blocks, slackBlock{
+		Type:     "context",
+		Elements: []slackText{{Type: "mrkdwn", Text: fmt.Sprintf("Source: %s · mimikry %s", report.SourceRepo, report.Version)}},
+	})
+
+	return message
+}
+
+// slackSection returns a section block with the given markdown text, truncated to the length Slack accepts.
+func slackSection(text string) slackBlock {
+	if len(text) > maxSlackSectionLength {
+		text = strings.ToValidUTF8(text[:maxSlackSectionLength-len("…")], "") + "…"
+	}
+
+	return slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: text}}
+}
+
+// notifySlack posts the given message to a Slack incoming webhook.
+func notifySlack(ctx context.Context, webhookURL string, message slackMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("encode slack message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
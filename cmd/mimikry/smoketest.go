@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nikoksr/mimikry/pkg/docker"
+	"github.com/nikoksr/simplog"
+)
+
+// defaultSmokeTestTimeout is the default time a smoke test may take, including the container's start.
+const defaultSmokeTestTimeout = time.Minute
+
+// runSmokeTest runs the given command line with the shell of the given image in a one-off container. It fails if the
+// command exits non-zero or doesn't finish within the timeout; zero means no timeout. The output is logged line by
+// line.
+func runSmokeTest(ctx context.Context, containers docker.ContainerClient, ref, commandLine string, timeout time.Duration) error {
+	logger := simplog.FromContext(ctx)
+
+	runCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	logger.Debugf("Running smoke test in %s: %s", ref, commandLine)
+
+	result, err := containers.Run(runCtx, ref, docker.RunOptions{Entrypoint: []string{"/bin/sh", "-c"}, Cmd: []string{commandLine}})
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("run smoke test: timed out after %s", timeout)
+		}
+
+		return fmt.Errorf("run smoke test: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(result.Output))
+	for scanner.Scan() {
+		logger.Infof("[smoke-test] %s", scanner.Text())
+	}
+
+	if result.ExitCode != 0 {
+		return fmt.Errorf("run smoke test: exit status %d", result.ExitCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/spf13/pflag"
+)
+
+// defaultCheckVersions are the versions templates are rendered for by default; one on each side of the InstallTools
+// boundary.
+var defaultCheckVersions = []string{"9.6", "16.2"}
+
+func printTemplateHelp(flags *pflag.FlagSet) func() {
+	return func() {
+		_, _ = fmt.Fprint(os.Stderr, `Usage:
+
+  mimikry template check [OPTIONS] SOURCE-FILE
+
+Parses all templates in the given directory and renders them for a set of versions, without touching docker.
+Reports syntax and execution errors with their file and line, and exits non-zero if there are any.
+
+Options:
+
+`)
+
+		flags.PrintDefaults()
+	}
+}
+
+// runTemplateCommand runs the template subcommand with the given arguments, i.e. everything after "mimikry template".
+func runTemplateCommand(args []string) error {
+	opts := &options{Maintainer: defaultMaintainer, Maintainers: []string{defaultMaintainer}}
+
+	flags := pflag.NewFlagSet("template", pflag.ContinueOnError)
+	rawVersions := flags.StringSlice("versions", defaultCheckVersions, "Comma-separated list of versions to render the templates for")
+	flags.StringVar(&opts.Tools, "tools", defaultDockerTools, "Comma-separated list of tools passed to the templates as .Tools")
+	flags.StringArrayVar(&opts.ToolsFor, "tools-for", nil, "Use a different list of tools for a range of versions; e.g. \">=12:vim,curl\"")
+	flags.StringArrayVar(&opts.TemplatesFor, "template-for", nil, "Use a different template as Dockerfile for a range of versions; e.g. \">=14:Dockerfile.new\"")
+	flags.StringArrayVar(&opts.Vars, "set", nil, "Set a template variable, available as .Extra.KEY; e.g. \"locale=de_DE\"")
+	flags.StringVar(&opts.VarsFile, "vars", "", "Path to a JSON or YAML file with template variables")
+	flags.StringArrayVar(&opts.ExtraEnvPairs, "extra-env", nil, "Environment variable for the images, available to templates as .ExtraEnv; e.g. \"TZ=UTC\"")
+	flags.StringVar(&opts.DefaultUser, "default-user", "", "Default user for the images, available to templates as .DefaultUser")
+	flags.Usage = printTemplateHelp(flags)
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+
+		return err
+	}
+
+	if flags.NArg() != 2 || flags.Arg(0) != "check" {
+		flags.Usage()
+		return errors.New("missing arguments; expected: mimikry template check SOURCE-FILE")
+	}
+
+	var err error
+	if opts.TemplateVars, err = mergeTemplateVars(opts.VarsFile, opts.Vars); err != nil {
+		return err
+	}
+
+	if opts.ExtraEnv, err = parseKeyValues(opts.ExtraEnvPairs); err != nil {
+		return err
+	}
+
+	if opts.TemplateMappings, err = parseVersionMappings(opts.TemplatesFor); err != nil {
+		return err
+	}
+
+	if opts.ToolMappings, err = parseVersionMappings(opts.ToolsFor); err != nil {
+		return err
+	}
+
+	versions := make([]*semver.Version, 0, len(*rawVersions))
+	for _, rawVersion := range *rawVersions {
+		version, err := semver.NewVersion(strings.TrimSpace(rawVersion))
+		if err != nil {
+			return fmt.Errorf("parse version %q: %w", rawVersion, err)
+		}
+		versions = append(versions, version)
+	}
+
+	dir := cleanPath(flags.Arg(1))
+	if err = checkTemplates(dir, versions, opts); err != nil {
+		return err
+	}
+
+	fmt.Printf("Templates in %s rendered for versions %s\n", dir, strings.Join(*rawVersions, ", "))
+
+	return nil
+}
+
+// checkTemplates parses the templates in the given directory and renders them for each version, discarding the
+// output. It returns all errors, joined.
+func checkTemplates(dir string, versions []*semver.Version, opts *options) error {
+	templates, err := parseTemplates(dir)
+	if err != nil {
+		return fmt.Errorf("parse templates: %w", err)
+	}
+
+	for _, mapping := range opts.TemplateMappings {
+		if templates.Lookup(mapping.Value) == nil {
+			return fmt.Errorf("mapped template %q not found in %s", mapping.Value, dir)
+		}
+	}
+
+	var errs []error
+	for _, version := range versions {
+		selected := selectTemplates(templates, version, opts.TemplateMappings)
+		if !slices.ContainsFunc(selected, func(s selectedTemplate) bool { return s.Output == "Dockerfile" }) {
+			errs = append(errs, fmt.Errorf("version %s: no Dockerfile template", version.Original()))
+		}
+
+		data := newTemplateData(version, opts)
+		for _, s := range selected {
+			if err = s.Template.Execute(io.Discard, data); err != nil {
+				errs = append(errs, fmt.Errorf("version %s: %w", version.Original(), err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// parseKeyValues parses a list of KEY=VALUE pairs into a map. Later pairs override earlier ones.
+func parseKeyValues(pairs []string) (map[string]string, error) {
+	values := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid key-value pair %q; expected KEY=VALUE", pair)
+		}
+
+		values[strings.TrimSpace(key)] = value
+	}
+
+	return values, nil
+}
+
+// loadTemplateVars loads template variables from a flat JSON or YAML file. Keys must not shadow the fields of
+// templateData to avoid confusing them with the built-in values.
+func loadTemplateVars(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read template variables: %w", err)
+	}
+
+	// JSON is a subset of YAML, so a single decoder handles both
+	var vars map[string]string
+	if err = yaml.Unmarshal(content, &vars); err != nil {
+		return nil, fmt.Errorf("decode template variables: %w", err)
+	}
+
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(templateData{})) {
+		if _, ok := vars[field.Name]; ok {
+			return nil, fmt.Errorf("template variable %q is reserved", field.Name)
+		}
+	}
+
+	return vars, nil
+}
+
+// loadBuildArgs loads the build args from the given env file and merges them with the given pairs; the pairs take
+// precedence. Like with docker build, a pair without a value, e.g. "HTTP_PROXY", takes its value from the environment
+// and is dropped if the variable is unset.
+func loadBuildArgs(path string, pairs []string) (map[string]string, error) {
+	args := make(map[string]string)
+	if path != "" {
+		fileArgs, err := godotenv.Read(path)
+		if err != nil {
+			return nil, fmt.Errorf("read build args: %w", err)
+		}
+
+		for key, value := range fileArgs {
+			args[key] = value
+		}
+	}
+
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("invalid build arg %q; expected KEY=VALUE or KEY", pair)
+		}
+
+		if !ok {
+			value, ok = os.LookupEnv(key)
+			if !ok {
+				delete(args, key)
+				continue
+			}
+		}
+
+		args[key] = value
+	}
+
+	return args, nil
+}
+
+// mergeTemplateVars merges the variables from the given file with the given KEY=VALUE pairs; the pairs take precedence.
+func mergeTemplateVars(path string, pairs []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	if path != "" {
+		fileVars, err := loadTemplateVars(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, value := range fileVars {
+			vars[key] = value
+		}
+	}
+
+	flagVars, err := parseKeyValues(pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range flagVars {
+		vars[key] = value
+	}
+
+	return vars, nil
+}
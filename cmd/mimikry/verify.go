@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// baseVerifier verifies the cosign signature of a base image, either against a public key or, keyless, against the
+// identity and OIDC issuer of the signing certificate.
+type baseVerifier struct {
+	Key      string
+	Identity string
+	Issuer   string
+}
+
+// cosignAvailable reports whether cosign is installed.
+func cosignAvailable() bool {
+	_, err := exec.LookPath("cosign")
+
+	return err == nil
+}
+
+// args returns the cosign arguments to verify the given image reference.
+func (v baseVerifier) args(ref string) []string {
+	args := []string{"verify"}
+	if v.Key != "" {
+		args = append(args, "--key", v.Key)
+	} else {
+		args = append(args, "--certificate-identity-regexp", v.Identity, "--certificate-oidc-issuer", v.Issuer)
+	}
+
+	return append(args, ref)
+}
+
+// verify checks that the given image reference is signed by a trusted signer. It fails if the image is unsigned or
+// none of its signatures can be verified.
+func (v baseVerifier) verify(ctx context.Context, ref string) error {
+	// #nosec G204 -- The arguments are passed directly to cosign, not through a shell.
+	if _, err := exec.CommandContext(ctx, "cosign", v.args(ref)...).Output(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("verify signature of %s: %w: %s", ref, err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+
+		return fmt.Errorf("verify signature of %s: %w", ref, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// version, commit and date are set at build time via -ldflags "-X main.version=... -X main.commit=... -X main.date=...".
+// If unset, the module version and the VCS information from the build info are used.
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+func getVersion() string {
+	if version != "" {
+		return version
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+
+	return "unknown"
+}
+
+// buildSetting returns the value of the given build info setting, e.g. vcs.revision, or fallback if it's unknown.
+func buildSetting(key, fallback string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return fallback
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == key && setting.Value != "" {
+			return setting.Value
+		}
+	}
+
+	return fallback
+}
+
+func getCommit() string {
+	if commit != "" {
+		return commit
+	}
+
+	return buildSetting("vcs.revision", "unknown")
+}
+
+func getBuildDate() string {
+	if date != "" {
+		return date
+	}
+
+	return buildSetting("vcs.time", "unknown")
+}
+
+// versionInfo returns the version, commit and build date of mimikry in a human-readable form.
+func versionInfo() string {
+	return fmt.Sprintf("mimikry %s (commit %s, built %s)", getVersion(), getCommit(), getBuildDate())
+}
+
+func defaultUserAgent() string {
+	return "mimikry/" + getVersion()
+}
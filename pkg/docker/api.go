@@ -5,59 +5,250 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nikoksr/simplog"
+	"golang.org/x/time/rate"
 )
 
-type registryTagsResponse struct {
+// dockerHubTagsResponse is a page of the Docker Hub tags API.
+type dockerHubTagsResponse struct {
 	Next    string `json:"next"`
 	Results []struct {
-		Name string `json:"name"`
+		Name          string    `json:"name"`
+		TagLastPushed time.Time `json:"tag_last_pushed"`
 	} `json:"results"`
 }
 
+type (
+	// userAgentTransport sets the User-Agent header on every request before handing it to the underlying transport.
+	userAgentTransport struct {
+		base http.RoundTripper
+	}
+
+	// rateLimitTransport waits for the rate limiter before handing a request to the underlying transport.
+	rateLimitTransport struct {
+		base    http.RoundTripper
+		limiter *rate.Limiter
+	}
+
+	// pageGuard stops a tag listing that doesn't come to an end; either because it exceeds the page limit or because
+	// the registry hands out a cursor it handed out before.
+	pageGuard struct {
+		repo    string
+		pages   int
+		cursors map[string]bool
+	}
+)
+
+// DefaultRegistryRPS is the default maximum number of registry API requests per second. It stays well below the rate
+// limits Docker Hub applies to anonymous clients.
+const DefaultRegistryRPS = 2.0
+
+// DefaultMaxTagPages is the default maximum number of pages fetched when listing the tags of a repository. At the
+// default page size, it allows for 100,000 tags, which is far more than any sane repository has.
+const DefaultMaxTagPages = 1000
+
 var (
-	patternRegistryTagsURL = "https://registry.hub.docker.com/v2/repositories/library/%s/tags?page=1&page_size=%d"
+	patternRegistryTagsURL = "https://registry.hub.docker.com/v2/repositories/%s/tags?page=1&page_size=%d"
 	registryAPIPageLimit   = 100
+	maxTagPages            = DefaultMaxTagPages
+
+	userAgent     = "mimikry"
+	httpTransport = newHTTPTransport()
+	rateLimiter   = rate.NewLimiter(rate.Limit(DefaultRegistryRPS), 1)
+	httpClient    = &http.Client{
+		Transport: &userAgentTransport{base: &rateLimitTransport{base: httpTransport, limiter: rateLimiter}},
+	}
 )
 
-func getTags(ctx context.Context, url string) ([]string, string, error) {
+// newHTTPTransport returns the transport used for registry API requests. It honors the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables.
+func newHTTPTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	return transport
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent)
+
+	return t.base.RoundTrip(req)
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, fmt.Errorf("wait for rate limiter: %w", err)
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// SetRateLimit limits registry API requests to the given number per second. A value of zero or less disables the
+// limit.
+func SetRateLimit(rps float64) {
+	if rps <= 0 {
+		rateLimiter.SetLimit(rate.Inf)
+		return
+	}
+
+	rateLimiter.SetLimit(rate.Limit(rps))
+}
+
+// SetMaxTagPages limits the number of pages fetched when listing the tags of a repository. Listings that hit the limit
+// end with a warning and return the tags fetched so far. A value of zero or less disables the limit. It is not safe to
+// call it concurrently with requests.
+func SetMaxTagPages(pages int) {
+	maxTagPages = pages
+}
+
+func newPageGuard(repo, cursor string) *pageGuard {
+	guard := &pageGuard{repo: repo, cursors: make(map[string]bool)}
+	if cursor != "" {
+		guard.cursors[cursor] = true
+	}
+
+	return guard
+}
+
+// next records a fetched page and returns the cursor of the next page to fetch. It returns an empty cursor, ending the
+// listing, if the page limit has been reached or the cursor has been seen before.
+func (g *pageGuard) next(ctx context.Context, cursor string) string {
+	g.pages++
+
+	if cursor == "" {
+		return ""
+	}
+
+	logger := simplog.FromContext(ctx)
+
+	if g.cursors[cursor] {
+		logger.Warnf("Registry returned a repeated page cursor for %s; stopping after %d pages", g.repo, g.pages)
+		return ""
+	}
+
+	if maxTagPages > 0 && g.pages >= maxTagPages {
+		logger.Warnf("Reached the limit of %d tag pages for %s; the tag list may be incomplete", maxTagPages, g.repo)
+		return ""
+	}
+
+	g.cursors[cursor] = true
+
+	return cursor
+}
+
+// SetUserAgent sets the User-Agent header that is sent with all registry API requests. It is not safe to call it
+// concurrently with requests.
+func SetUserAgent(ua string) {
+	userAgent = ua
+}
+
+// SetProxy routes all registry API requests, i.e. tag discovery, through the given proxy URL, overriding the proxy
+// environment variables. Image pulls and pushes are performed by the docker daemon and use its proxy configuration
+// instead. It is not safe to call it concurrently with requests.
+func SetProxy(proxyURL string) error {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return fmt.Errorf("parse proxy url: %w", err)
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid proxy url %q; expected scheme://host[:port]", proxyURL)
+	}
+
+	httpTransport.Proxy = http.ProxyURL(u)
+
+	return nil
+}
+
+func getTags(ctx context.Context, url string) ([]Tag, string, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, "", fmt.Errorf("create request: %w", err)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, "", fmt.Errorf("send request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	var registryResponse registryTagsResponse
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, "", ErrRepoNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var registryResponse dockerHubTagsResponse
 	if err = json.NewDecoder(resp.Body).Decode(&registryResponse); err != nil {
 		return nil, "", fmt.Errorf("decode response: %w", err)
 	}
 
-	tags := make([]string, 0, len(registryResponse.Results))
+	tags := make([]Tag, 0, len(registryResponse.Results))
 	for _, result := range registryResponse.Results {
-		tags = append(tags, result.Name)
+		tags = append(tags, Tag{Name: result.Name, Pushed: result.TagLastPushed})
 	}
 
 	return tags, registryResponse.Next, nil
 }
 
-func getAllTags(ctx context.Context, repo string) ([]string, error) {
-	var tags []string
+// dockerHubRepoPath returns the namespaced path of the given Docker Hub repository, as used by the Docker Hub API; e.g.
+// library/postgres for postgres or docker.io/postgres, and bitnami/postgresql for bitnami/postgresql. Repositories
+// without a namespace are official images, which live in the library namespace.
+func dockerHubRepoPath(repo string) string {
+	if host, path, found := strings.Cut(repo, "/"); found && slices.Contains(dockerHubHosts, host) {
+		repo = path
+	}
+
+	if !strings.Contains(repo, "/") {
+		return "library/" + repo
+	}
+
+	return repo
+}
+
+func getAllTags(ctx context.Context, repo string) ([]Tag, error) {
+	var tags []Tag
+	err := walkTags(ctx, repo, "", func(page []Tag, _ string) error {
+		tags = append(tags, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	next := fmt.Sprintf(patternRegistryTagsURL, repo, registryAPIPageLimit)
+	return tags, nil
+}
+
+// walkTags fetches the tags of the given repository page by page, starting at cursor or, if cursor is empty, at the
+// first page, and calls fn after every page. The listing is capped by the page limit set with SetMaxTagPages.
+func walkTags(ctx context.Context, repo, cursor string, fn PageFunc) error {
+	next := cursor
+	if next == "" {
+		next = fmt.Sprintf(patternRegistryTagsURL, dockerHubRepoPath(repo), registryAPIPageLimit)
+	}
+
+	guard := newPageGuard(repo, next)
 	for next != "" {
 		var err error
-		var newTags []string
+		var newTags []Tag
 		newTags, next, err = getTags(ctx, next)
 		if err != nil {
-			return nil, fmt.Errorf("get tags: %w", err)
+			return fmt.Errorf("get tags: %w", err)
 		}
 
-		tags = append(tags, newTags...)
+		next = guard.next(ctx, next)
+
+		if err = fn(newTags, next); err != nil {
+			return err
+		}
 	}
 
-	return tags, nil
+	return nil
 }
@@ -0,0 +1,58 @@
+package docker
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDockerHubRepoPath(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		repo    string
+		want    string
+		wantURL string
+	}{
+		{
+			repo:    "postgres",
+			want:    "library/postgres",
+			wantURL: "https://registry.hub.docker.com/v2/repositories/library/postgres/tags?page=1&page_size=100",
+		},
+		{
+			repo:    "library/postgres",
+			want:    "library/postgres",
+			wantURL: "https://registry.hub.docker.com/v2/repositories/library/postgres/tags?page=1&page_size=100",
+		},
+		{
+			repo:    "someuser/somerepo",
+			want:    "someuser/somerepo",
+			wantURL: "https://registry.hub.docker.com/v2/repositories/someuser/somerepo/tags?page=1&page_size=100",
+		},
+		{
+			repo:    "docker.io/postgres",
+			want:    "library/postgres",
+			wantURL: "https://registry.hub.docker.com/v2/repositories/library/postgres/tags?page=1&page_size=100",
+		},
+		{
+			repo:    "docker.io/someuser/somerepo",
+			want:    "someuser/somerepo",
+			wantURL: "https://registry.hub.docker.com/v2/repositories/someuser/somerepo/tags?page=1&page_size=100",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.repo, func(t *testing.T) {
+			t.Parallel()
+
+			got := dockerHubRepoPath(tt.repo)
+			if got != tt.want {
+				t.Errorf("dockerHubRepoPath(%q) = %q, want %q", tt.repo, got, tt.want)
+			}
+
+			if gotURL := fmt.Sprintf(patternRegistryTagsURL, got, registryAPIPageLimit); gotURL != tt.wantURL {
+				t.Errorf("tags URL of %q = %q, want %q", tt.repo, gotURL, tt.wantURL)
+			}
+		})
+	}
+}
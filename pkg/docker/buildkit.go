@@ -0,0 +1,407 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+	"github.com/moby/buildkit/session/sshforward/sshprovider"
+	"github.com/nikoksr/simplog"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// BuildBackend is the builder of the docker daemon used for image builds.
+type BuildBackend string
+
+const (
+	// BuildBackendAuto uses BuildKit if the daemon reports it as its builder, and the classic builder otherwise.
+	// Builds BuildKit fails to start, e.g. because the daemon rejects the session, are retried with the classic
+	// builder. This is the default.
+	BuildBackendAuto BuildBackend = "auto"
+	// BuildBackendBuildKit always uses BuildKit.
+	BuildBackendBuildKit BuildBackend = "buildkit"
+	// BuildBackendClassic always uses the classic, legacy builder.
+	BuildBackendClassic BuildBackend = "classic"
+)
+
+// auxBuildKitTrace is the ID of the aux messages carrying the progress of BuildKit builds.
+const auxBuildKitTrace = "moby.buildkit.trace"
+
+const (
+	// buildKitSessionName is the name BuildKit sessions are opened with.
+	buildKitSessionName = "mimikry"
+	// buildKitImageExporter is the BuildKit exporter storing the built image in the containerd image store.
+	buildKitImageExporter = "image"
+	// buildArgAttestProvenance is the build arg BuildKit reads the provenance attestation options from.
+	buildArgAttestProvenance = "BUILDKIT_ATTEST_PROVENANCE"
+)
+
+// errBuildKitNotStarted is returned by BuildKit builds that failed before BuildKit started working on them; e.g.
+// because the daemon rejected the build request or its session.
+var errBuildKitNotStarted = errors.New("BuildKit didn't start the build")
+
+// BuildBackends lists all supported build backends.
+var BuildBackends = []BuildBackend{BuildBackendAuto, BuildBackendBuildKit, BuildBackendClassic}
+
+// patternBuildKitStep matches the names of the BuildKit vertices that are Dockerfile instructions; e.g.
+// "[2/5] RUN apt-get update" or "[builder 2/5] RUN make". Internal vertices, like loading the build context, don't
+// match.
+var patternBuildKitStep = regexp.MustCompile(`^\[(?:\S+ )?(\d+)/\d+\] (.*)$`)
+
+type (
+	// BuildSecret is a secret exposed to the RUN --mount=type=secret instructions of BuildKit builds.
+	BuildSecret struct {
+		// ID is the id the secret is mounted by.
+		ID string
+		// Src is the path of the file holding the secret.
+		Src string
+		// Env is the environment variable holding the secret; it's used if Src is empty.
+		Env string
+	}
+
+	// BuildSSH is an SSH agent forwarded to the RUN --mount=type=ssh instructions of BuildKit builds.
+	BuildSSH struct {
+		// ID is the id the agent is mounted by; e.g. "default".
+		ID string
+		// Paths are the agent socket or the private keys to serve. Empty means the agent of SSH_AUTH_SOCK.
+		Paths []string
+	}
+
+	// buildKitVertex is a vertex of a BuildKit build, e.g. a Dockerfile instruction, as reported in its trace.
+	buildKitVertex struct {
+		Digest    string
+		Name      string
+		Error     string
+		Started   time.Time
+		Completed time.Time
+	}
+
+	// buildKitTracer follows the trace of a BuildKit build. The trace reports vertices repeatedly while they progress,
+	// so finished ones are remembered to report each only once.
+	buildKitTracer struct {
+		done    map[string]bool
+		started bool
+	}
+)
+
+// WithBuildBackend sets the builder used for image builds.
+func WithBuildBackend(backend BuildBackend) Option {
+	return func(c *Client) {
+		c.buildBackend = backend
+	}
+}
+
+// resolveBuildBackend returns the build backend to use with a daemon that reports the given builder version.
+// Explicitly chosen backends are returned as they are.
+func resolveBuildBackend(backend BuildBackend, daemonBuilder types.BuilderVersion) BuildBackend {
+	if backend != BuildBackendAuto && backend != "" {
+		return backend
+	}
+
+	if daemonBuilder == types.BuilderBuildKit {
+		return BuildBackendBuildKit
+	}
+
+	return BuildBackendClassic
+}
+
+// needsBuildKit reports whether the given build options use features only BuildKit supports.
+func needsBuildKit(opts BuildOptions) bool {
+	return len(opts.Secrets) > 0 || len(opts.SSH) > 0 || opts.OCIMediaTypes || opts.Provenance != ""
+}
+
+// buildKitOutputs returns the exporters of BuildKit builds with the given options. By default, the daemon picks its
+// own exporter, which stores single images in the media types of its image store. Other media types and attestations
+// need the image exporter, which is only available with the containerd image store. It switches to OCI media types
+// for images with attestations by itself.
+func buildKitOutputs(opts BuildOptions) []types.ImageBuildOutput {
+	if !opts.OCIMediaTypes && opts.Provenance == "" {
+		return nil
+	}
+
+	attrs := make(map[string]string)
+	if opts.OCIMediaTypes {
+		attrs["oci-mediatypes"] = "true"
+	}
+
+	return []types.ImageBuildOutput{{Type: buildKitImageExporter, Attrs: attrs}}
+}
+
+// buildKitAttestations returns the build args requesting the attestations of the given options. The daemon's build
+// API has no attestation options, but BuildKit reads them from build args, too.
+func buildKitAttestations(opts BuildOptions) map[string]string {
+	if opts.Provenance == "" {
+		return nil
+	}
+
+	return map[string]string{buildArgAttestProvenance: "mode=" + opts.Provenance}
+}
+
+// startBuildKitSession opens a BuildKit session on the daemon, through which BuildKit calls back into the client
+// during builds; e.g. for the secrets and SSH agents of the given options. The caller has to close it once the build
+// is done.
+func (c *imageClient) startBuildKitSession(ctx context.Context, opts BuildOptions) (*session.Session, error) {
+	logger := simplog.FromContext(ctx)
+	client := c.provider.GetDockerClient()
+
+	s, err := session.NewSession(ctx, buildKitSessionName, "")
+	if err != nil {
+		return nil, fmt.Errorf("create BuildKit session: %w", err)
+	}
+
+	if len(opts.Secrets) > 0 {
+		sources := make([]secretsprovider.Source, 0, len(opts.Secrets))
+		for _, secret := range opts.Secrets {
+			sources = append(sources, secretsprovider.Source{ID: secret.ID, FilePath: secret.Src, Env: secret.Env})
+		}
+
+		store, err := secretsprovider.NewStore(sources)
+		if err != nil {
+			return nil, fmt.Errorf("load build secrets: %w", err)
+		}
+		s.Allow(secretsprovider.NewSecretProvider(store))
+	}
+
+	if len(opts.SSH) > 0 {
+		configs := make([]sshprovider.AgentConfig, 0, len(opts.SSH))
+		for _, agent := range opts.SSH {
+			configs = append(configs, sshprovider.AgentConfig{ID: agent.ID, Paths: agent.Paths})
+		}
+
+		provider, err := sshprovider.NewSSHAgentProvider(configs)
+		if err != nil {
+			return nil, fmt.Errorf("load SSH agents: %w", err)
+		}
+		s.Allow(provider)
+	}
+
+	dialer := func(ctx context.Context, proto string, meta map[string][]string) (net.Conn, error) {
+		return client.DialHijack(ctx, "/session", proto, meta)
+	}
+
+	// The session runs until it's closed; builds referring to it wait for it to connect
+	go func() {
+		if err := s.Run(ctx, dialer); err != nil {
+			logger.Debugf("BuildKit session %s ended: %v", s.ID(), err)
+		}
+	}()
+
+	return s, nil
+}
+
+// finalBaseImage returns the reference of the image the final stage of the given Dockerfile is built on, with stage
+// names resolved. It's empty if the stage is built from scratch or on a reference that can't be resolved statically,
+// e.g. because it uses build args.
+func finalBaseImage(dockerfile []byte) string {
+	stages := make(map[string]string)
+	var base string
+
+	scanner := bufio.NewScanner(bytes.NewReader(dockerfile))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+
+		// Skip flags like --platform
+		args := fields[1:]
+		for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		base = args[0]
+		if stage, ok := stages[strings.ToLower(base)]; ok {
+			base = stage
+		}
+
+		if len(args) == 3 && strings.EqualFold(args[1], "AS") {
+			stages[strings.ToLower(args[2])] = base
+		}
+	}
+
+	if strings.EqualFold(base, "scratch") || strings.Contains(base, "$") {
+		return ""
+	}
+
+	return base
+}
+
+// hasLayerPrefix reports whether the given base layers are the bottom layers of the given image layers.
+func hasLayerPrefix(imageLayers, baseLayers []string) bool {
+	if len(baseLayers) == 0 || len(baseLayers) > len(imageLayers) {
+		return false
+	}
+
+	for i, layer := range baseLayers {
+		if imageLayers[i] != layer {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newBuildKitTracer() *buildKitTracer {
+	return &buildKitTracer{done: make(map[string]bool)}
+}
+
+// observe processes the aux payload of a trace message and returns the build steps it finished and the errors of
+// failed vertices.
+func (t *buildKitTracer) observe(aux json.RawMessage) ([]BuildStep, []string, error) {
+	// The payload is a protobuf encoded status response, which JSON carries as base64 string
+	var data []byte
+	if err := json.Unmarshal(aux, &data); err != nil {
+		return nil, nil, fmt.Errorf("decode trace message: %w", err)
+	}
+
+	vertices, err := decodeBuildKitVertices(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode trace message: %w", err)
+	}
+	if len(vertices) > 0 {
+		t.started = true
+	}
+
+	var steps []BuildStep
+	var errLines []string
+	for _, vertex := range vertices {
+		if vertex.Completed.IsZero() || t.done[vertex.Digest] {
+			continue
+		}
+		t.done[vertex.Digest] = true
+
+		if vertex.Error != "" {
+			errLines = append(errLines, fmt.Sprintf("%s: %s", vertex.Name, vertex.Error))
+		}
+
+		match := patternBuildKitStep.FindStringSubmatch(vertex.Name)
+		if match == nil || vertex.Started.IsZero() {
+			continue
+		}
+
+		number, _ := strconv.Atoi(match[1])
+		steps = append(steps, BuildStep{Number: number, Instruction: match[2], Duration: vertex.Completed.Sub(vertex.Started)})
+	}
+
+	return steps, errLines, nil
+}
+
+// decodeBuildKitVertices decodes the vertices of a BuildKit status response. Only the fields needed to follow a build
+// are decoded; the status response is defined in BuildKit's control API as:
+//
+//	message StatusResponse { repeated Vertex vertexes = 1; ... }
+//	message Vertex { string digest = 1; ...; string name = 3; ...; Timestamp started = 5; Timestamp completed = 6; string error = 7; ... }
+func decodeBuildKitVertices(data []byte) ([]buildKitVertex, error) {
+	var vertices []buildKitVertex
+	err := walkProtoFields(data, func(num protowire.Number, value []byte) error {
+		if num != 1 {
+			return nil
+		}
+
+		var vertex buildKitVertex
+		err := walkProtoFields(value, func(num protowire.Number, value []byte) error {
+			var err error
+			switch num {
+			case 1:
+				vertex.Digest = string(value)
+			case 3:
+				vertex.Name = string(value)
+			case 5:
+				vertex.Started, err = decodeProtoTimestamp(value)
+			case 6:
+				vertex.Completed, err = decodeProtoTimestamp(value)
+			case 7:
+				vertex.Error = string(value)
+			}
+
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("decode vertex: %w", err)
+		}
+
+		vertices = append(vertices, vertex)
+
+		return nil
+	})
+
+	return vertices, err
+}
+
+// decodeProtoTimestamp decodes a google.protobuf.Timestamp.
+func decodeProtoTimestamp(data []byte) (time.Time, error) {
+	var seconds, nanos int64
+	err := walkProtoFields(data, func(num protowire.Number, value []byte) error {
+		varint, n := protowire.ConsumeVarint(value)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		switch num {
+		case 1:
+			seconds = int64(varint)
+		case 2:
+			nanos = int64(varint)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("decode timestamp: %w", err)
+	}
+
+	return time.Unix(seconds, nanos), nil
+}
+
+// walkProtoFields calls fn with the number and raw value of each field of the given protobuf message. Values of
+// varint fields are passed in their encoded form, values of length-delimited fields without their length prefix.
+func walkProtoFields(data []byte, fn func(num protowire.Number, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var value []byte
+		switch typ {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(data)
+		case protowire.VarintType:
+			_, n = protowire.ConsumeVarint(data)
+			if n >= 0 {
+				value = data[:n]
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if value == nil {
+			continue
+		}
+
+		if err := fn(num, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestResolveBuildBackend(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		backend       BuildBackend
+		daemonBuilder types.BuilderVersion
+		want          BuildBackend
+	}{
+		{name: "auto with BuildKit daemon", backend: BuildBackendAuto, daemonBuilder: types.BuilderBuildKit, want: BuildBackendBuildKit},
+		{name: "auto with classic daemon", backend: BuildBackendAuto, daemonBuilder: types.BuilderV1, want: BuildBackendClassic},
+		{name: "auto with unknown daemon", backend: BuildBackendAuto, want: BuildBackendClassic},
+		{name: "unset", daemonBuilder: types.BuilderBuildKit, want: BuildBackendBuildKit},
+		{name: "forced BuildKit", backend: BuildBackendBuildKit, daemonBuilder: types.BuilderV1, want: BuildBackendBuildKit},
+		{name: "forced classic", backend: BuildBackendClassic, daemonBuilder: types.BuilderBuildKit, want: BuildBackendClassic},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := resolveBuildBackend(tt.backend, tt.daemonBuilder); got != tt.want {
+				t.Errorf("resolveBuildBackend(%q, %q) = %q, want %q", tt.backend, tt.daemonBuilder, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFinalBaseImage(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		dockerfile string
+		want       string
+	}{
+		{name: "single stage", dockerfile: "FROM postgres:16.3\nRUN true\n", want: "postgres:16.3"},
+		{name: "platform flag", dockerfile: "FROM --platform=linux/arm64 postgres:16.3\n", want: "postgres:16.3"},
+		{name: "multi stage", dockerfile: "FROM golang:1.22 AS builder\nRUN make\nFROM postgres:16.3\nCOPY --from=builder /app /app\n", want: "postgres:16.3"},
+		{name: "stage alias", dockerfile: "FROM postgres:16.3 AS base\nRUN true\nFROM base\n", want: "postgres:16.3"},
+		{name: "nested stage alias", dockerfile: "from postgres:16.3 as base\nFROM BASE AS runtime\nFROM runtime\n", want: "postgres:16.3"},
+		{name: "scratch", dockerfile: "FROM golang:1.22 AS builder\nFROM scratch\n", want: ""},
+		{name: "build arg", dockerfile: "ARG VERSION\nFROM postgres:${VERSION}\n", want: ""},
+		{name: "no FROM", dockerfile: "RUN true\n", want: ""},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := finalBaseImage([]byte(tt.dockerfile)); got != tt.want {
+				t.Errorf("finalBaseImage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasLayerPrefix(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		imageLayers []string
+		baseLayers  []string
+		want        bool
+	}{
+		{name: "built on base", imageLayers: []string{"a", "b", "c"}, baseLayers: []string{"a", "b"}, want: true},
+		{name: "no new layers", imageLayers: []string{"a", "b"}, baseLayers: []string{"a", "b"}, want: true},
+		{name: "other base", imageLayers: []string{"a", "b", "c"}, baseLayers: []string{"a", "x"}, want: false},
+		{name: "base larger", imageLayers: []string{"a"}, baseLayers: []string{"a", "b"}, want: false},
+		{name: "empty base", imageLayers: []string{"a"}, want: false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := hasLayerPrefix(tt.imageLayers, tt.baseLayers); got != tt.want {
+				t.Errorf("hasLayerPrefix(%v, %v) = %v, want %v", tt.imageLayers, tt.baseLayers, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/nikoksr/simplog"
+)
+
+var _ ContainerClient = (*containerClient)(nil)
+
+type (
+	// ContainerClient is a client for docker containers. It is used to run one-off commands in images.
+	ContainerClient interface {
+		Run(ctx context.Context, ref string, opts RunOptions) (RunResult, error)
+	}
+
+	// RunOptions configures a one-off container.
+	RunOptions struct {
+		// Entrypoint overrides the entrypoint of the image, if set.
+		Entrypoint []string
+		// Cmd overrides the command of the image, if set.
+		Cmd []string
+	}
+
+	// RunResult is the outcome of a one-off container.
+	RunResult struct {
+		// ExitCode is the exit code of the container's main process.
+		ExitCode int64
+		// Output is the combined stdout and stderr of the container.
+		Output []byte
+	}
+
+	// Actual implementation of ContainerClient
+	containerClient struct {
+		provider provider
+	}
+)
+
+// containerRemoveTimeout bounds the removal of a container once it's done, even if the run's context is canceled.
+const containerRemoveTimeout = 30 * time.Second
+
+func (c *Client) Containers() ContainerClient {
+	return &containerClient{provider: c}
+}
+
+// Run creates a container from the given image, starts it and waits for it to exit. The container is removed
+// afterwards, also if the context is canceled while it's running. A non-zero exit code is not an error; check the
+// exit code of the result.
+func (c *containerClient) Run(ctx context.Context, ref string, opts RunOptions) (RunResult, error) {
+	logger := simplog.FromContext(ctx)
+	client := c.provider.GetDockerClient()
+
+	created, err := client.ContainerCreate(ctx, &container.Config{
+		Image:      ref,
+		Entrypoint: opts.Entrypoint,
+		Cmd:        opts.Cmd,
+	}, &container.HostConfig{}, nil, nil, "")
+	if err != nil {
+		return RunResult{}, fmt.Errorf("create container from %q: %w", ref, err)
+	}
+
+	logger.Debugf("Created container %s from %s", created.ID, ref)
+
+	defer func() {
+		removeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), containerRemoveTimeout)
+		defer cancel()
+
+		if err := client.ContainerRemove(removeCtx, created.ID, container.RemoveOptions{Force: true}); err != nil {
+			logger.Warnf("Failed to remove container %s: %v", created.ID, err)
+		}
+	}()
+
+	// Subscribe before starting, so a container exiting right away isn't missed
+	statusCh, errCh := client.ContainerWait(ctx, created.ID, container.WaitConditionNextExit)
+
+	if err = client.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+		return RunResult{}, fmt.Errorf("start container %s: %w", created.ID, err)
+	}
+
+	var result RunResult
+	select {
+	case status := <-statusCh:
+		if status.Error != nil {
+			return RunResult{}, fmt.Errorf("wait for container %s: %s", created.ID, status.Error.Message)
+		}
+		result.ExitCode = status.StatusCode
+	case err = <-errCh:
+		return RunResult{}, fmt.Errorf("wait for container %s: %w", created.ID, err)
+	}
+
+	logs, err := client.ContainerLogs(ctx, created.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return RunResult{}, fmt.Errorf("get logs of container %s: %w", created.ID, err)
+	}
+	defer logs.Close()
+
+	var output bytes.Buffer
+	if _, err = stdcopy.StdCopy(&output, &output, logs); err != nil {
+		return RunResult{}, fmt.Errorf("read logs of container %s: %w", created.ID, err)
+	}
+	result.Output = output.Bytes()
+
+	return result, nil
+}
@@ -1,12 +1,21 @@
 package docker
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/registry"
 	docker "github.com/docker/docker/client"
 	"github.com/nikoksr/simplog"
@@ -21,60 +30,228 @@ type (
 	provider interface {
 		GetDockerClient() *docker.Client
 		GetAuthToken() string
+		retryOnDisconnect(ctx context.Context, fn func() error) error
 	}
 
 	// Client is the main docker client. It is used to create other clients.
 	Client struct {
+		mu           sync.RWMutex // Guards dockerClient, which is replaced on reconnects
 		dockerClient *docker.Client
+		host         string
+
+		reconnectAttempts int
 
-		authToken string // base64 encoded auth config, used for registry operations. Gets set by Login methods.
+		authToken         string // base64 encoded auth config, used for registry operations. Gets set by Login methods.
+		baseStrategy      BaseStrategy
+		buildBackend      BuildBackend
+		buildKitFallback  bool // Whether BuildKit builds that didn't start are retried with the classic builder
+		removeConcurrency int
+		pacer             *pushPacer
 	}
 
-	// ImageClient is a client for docker images. It is used to build, tag, push and remove docker images.
+	// Option configures a Client.
+	Option func(*Client)
+
+	// BaseStrategy is the strategy used to detect the base image of a built image.
+	BaseStrategy string
+
+	// ImageClient is a client for docker images. It is used to build, tag, push, save, load, inspect and remove
+	// docker images.
 	ImageClient interface {
-		Build(ctx context.Context, dockerfile string, tags ...string) (string, string, error)
-		Push(ctx context.Context, images ...string) error
+		Build(ctx context.Context, buildDir string, opts BuildOptions, tags ...string) (string, string, error)
+		BuildFiles(ctx context.Context, files map[string][]byte, opts BuildOptions, tags ...string) (string, string, error)
+		Tag(ctx context.Context, source string, tags ...string) error
+		Untag(ctx context.Context, tags ...string) error
+		Push(ctx context.Context, images ...string) (map[string]string, error)
 		Remove(ctx context.Context, ids ...string) error
+		List(ctx context.Context, labels ...string) ([]image.Summary, error)
+		Save(ctx context.Context, ref string, w io.Writer) error
+		Load(ctx context.Context, r io.Reader) ([]string, error)
+		Inspect(ctx context.Context, ref string) (types.ImageInspect, error)
+		RemoteDigest(ctx context.Context, ref string) (string, error)
+	}
+
+	// BuildOptions configures a single image build.
+	BuildOptions struct {
+		// Platform is the target platform in the format os/arch[/variant]; e.g. linux/arm64. Empty means the daemon's
+		// native platform.
+		Platform string
+		// BuildArgs are passed to the build as ARG values.
+		BuildArgs map[string]string
+		// Labels are set on the built image.
+		Labels map[string]string
+		// DockerignorePath is the path of an ignore file applied to the build context in addition to the build
+		// directory's .dockerignore.
+		DockerignorePath string
+		// OnStep, if set, is called with the timing of each build step once it's done.
+		OnStep func(step BuildStep)
+		// SourceDateEpoch, if set, is passed to the build as the SOURCE_DATE_EPOCH build arg and used as modification
+		// time of the files of in-memory build contexts.
+		SourceDateEpoch time.Time
+		// Memory limits the memory of the build containers in bytes; zero means no limit.
+		Memory int64
+		// CPUs limits the build containers to the given number of CPUs; e.g. 1.5. Zero means no limit.
+		CPUs float64
+		// Ulimits are the ulimits of the build containers.
+		Ulimits []*container.Ulimit
+		// ContextCompression is the gzip level the build context is sent to the daemon with, from 1 (fastest) to 9
+		// (smallest). Zero sends it uncompressed, which is the fastest option for a local daemon.
+		ContextCompression int
+		// ContextExcludeVCS leaves version control directories, like .git, out of the build context.
+		ContextExcludeVCS bool
+		// Secrets are exposed to RUN --mount=type=secret instructions. They need BuildKit.
+		Secrets []BuildSecret
+		// SSH are the SSH agents forwarded to RUN --mount=type=ssh instructions. They need BuildKit.
+		SSH []BuildSSH
+		// OCIMediaTypes exports the image with OCI instead of Docker media types, which pushes keep. It needs BuildKit
+		// and the containerd image store.
+		OCIMediaTypes bool
+		// Provenance, if set, attaches a provenance attestation of the given detail to the image; ProvenanceMin or
+		// ProvenanceMax. Attestations are stored in an OCI image index, so it needs BuildKit and the containerd image
+		// store, too.
+		Provenance string
 	}
 
 	// Actual implementation of ImageClient
 	imageClient struct {
-		provider provider
+		provider          provider
+		baseStrategy      BaseStrategy
+		buildBackend      BuildBackend
+		buildKitFallback  bool
+		removeConcurrency int
+		pacer             *pushPacer
 	}
 )
 
-func newProvider() (*Client, error) {
-	client, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+const (
+	// BaseStrategyHistory uses the oldest image history entry that is known locally. This is the default.
+	BaseStrategyHistory BaseStrategy = "history"
+	// BaseStrategyFromLabel resolves the image referenced by the LabelBaseName label of the built image.
+	BaseStrategyFromLabel BaseStrategy = "from-label"
+	// BaseStrategyInspectDigest resolves the local image whose repo digests contain the digest from the LabelBaseDigest
+	// label of the built image.
+	BaseStrategyInspectDigest BaseStrategy = "inspect-digest"
+
+	// LabelBaseName is the OCI annotation holding the reference of an image's base image.
+	LabelBaseName = "org.opencontainers.image.base.name"
+	// LabelBaseDigest is the OCI annotation holding the digest of an image's base image.
+	LabelBaseDigest = "org.opencontainers.image.base.digest"
+
+	// BuildArgSourceDateEpoch is the build arg carrying the SOURCE_DATE_EPOCH of reproducible builds.
+	BuildArgSourceDateEpoch = "SOURCE_DATE_EPOCH"
+
+	// ProvenanceMin records the basic facts of a build in its provenance attestation, like the build args and the
+	// source images.
+	ProvenanceMin = "min"
+	// ProvenanceMax adds the full build definition to the provenance attestation.
+	ProvenanceMax = "max"
+)
+
+// containerdSnapshotterDriverType is the driver type the docker daemon reports when using the containerd image store.
+const containerdSnapshotterDriverType = "io.containerd.snapshotter.v1"
+
+// DefaultRemoveConcurrency is the default number of images removed concurrently.
+const DefaultRemoveConcurrency = 4
+
+// BaseStrategies lists all supported base detection strategies.
+var BaseStrategies = []BaseStrategy{BaseStrategyHistory, BaseStrategyFromLabel, BaseStrategyInspectDigest}
+
+// WithRemoveConcurrency sets the maximum number of images removed concurrently.
+func WithRemoveConcurrency(n int) Option {
+	return func(c *Client) {
+		c.removeConcurrency = n
+	}
+}
+
+// WithHost sets the address of the docker daemon; e.g. tcp://builder:2376 or unix:///var/run/docker.sock. It
+// overrides the DOCKER_HOST environment variable.
+func WithHost(host string) Option {
+	return func(c *Client) {
+		c.host = host
+	}
+}
+
+// ValidateHost reports whether host is a valid docker daemon address.
+func ValidateHost(host string) error {
+	if _, err := docker.ParseHostURL(host); err != nil {
+		return fmt.Errorf("invalid docker host %q: %w", host, err)
+	}
+
+	return nil
+}
+
+// WithBaseStrategy sets the strategy used to detect the base image of built images.
+func WithBaseStrategy(strategy BaseStrategy) Option {
+	return func(c *Client) {
+		c.baseStrategy = strategy
+	}
+}
+
+func newProvider(opts ...Option) (*Client, error) {
+	provider := &Client{
+		baseStrategy:      BaseStrategyHistory,
+		buildBackend:      BuildBackendAuto,
+		removeConcurrency: DefaultRemoveConcurrency,
+		pacer:             &pushPacer{},
+	}
+
+	for _, opt := range opts {
+		opt(provider)
+	}
+
+	client, err := newDockerClient(provider.host)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Client{dockerClient: client}, nil
+	provider.dockerClient = client
+
+	return provider, nil
+}
+
+// newDockerClient creates an API client for the daemon at the given host or, if host is empty, the one configured
+// through the environment.
+func newDockerClient(host string) (*docker.Client, error) {
+	clientOpts := []docker.Opt{docker.FromEnv, docker.WithAPIVersionNegotiation()}
+	if host != "" {
+		clientOpts = append(clientOpts, docker.WithHost(host))
+	}
+
+	return docker.NewClientWithOpts(clientOpts...)
 }
 
 // New returns a new docker client.
-func New(ctx context.Context) (*Client, error) {
+func New(ctx context.Context, opts ...Option) (*Client, error) {
 	logger := simplog.FromContext(ctx)
 
 	logger.Debug("create new docker client")
-	provider, err := newProvider()
+	provider, err := newProvider(opts...)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrDaemonUnreachable, err)
 	}
 
 	// Ping the docker daemon to check if it is running.
 	logger.Debug("ping docker daemon")
 	resp, err := provider.dockerClient.Ping(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("ping docker daemon: %w", err)
+		return nil, fmt.Errorf("ping docker daemon at %s: %w: %w", provider.dockerClient.DaemonHost(), ErrDaemonUnreachable, err)
 	}
 
 	logger.Debugf("docker daemon responded with: %+v", resp)
 
+	// Settle on a builder; the daemon reports BuildKit as its builder if it supports and prefers it. Only a BuildKit
+	// picked automatically falls back to the classic builder.
+	provider.buildKitFallback = provider.buildBackend == BuildBackendAuto || provider.buildBackend == ""
+	provider.buildBackend = resolveBuildBackend(provider.buildBackend, resp.BuilderVersion)
+	logger.Debugf("using build backend %s", provider.buildBackend)
+
 	return provider, nil
 }
 
 func (c *Client) GetDockerClient() *docker.Client {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	return c.dockerClient
 }
 
@@ -83,13 +260,80 @@ func (c *Client) GetAuthToken() string {
 }
 
 func (c *Client) Images() ImageClient {
-	return &imageClient{provider: c}
+	return &imageClient{
+		provider:          c,
+		baseStrategy:      c.baseStrategy,
+		buildBackend:      c.buildBackend,
+		buildKitFallback:  c.buildKitFallback,
+		removeConcurrency: c.removeConcurrency,
+		pacer:             c.pacer,
+	}
+}
+
+// BuildBackend returns the builder used for image builds. Once the client is created, it is never BuildBackendAuto.
+func (c *Client) BuildBackend() BuildBackend {
+	return c.buildBackend
+}
+
+// ContainerdImageStore reports whether the docker daemon keeps images in the containerd image store. Unlike the
+// classic one, it keeps images in the media types they were built with, and their attestations.
+func (c *Client) ContainerdImageStore(ctx context.Context) (bool, error) {
+	info, err := c.GetDockerClient().Info(ctx)
+	if err != nil {
+		return false, fmt.Errorf("get daemon info: %w", err)
+	}
+
+	for _, status := range info.DriverStatus {
+		if len(status) == 2 && status[0] == "driver-type" && status[1] == containerdSnapshotterDriverType {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Platform returns the native platform of the docker daemon in the format os/arch; e.g. linux/amd64.
+func (c *Client) Platform(ctx context.Context) (string, error) {
+	info, err := c.GetDockerClient().Info(ctx)
+	if err != nil {
+		return "", fmt.Errorf("get daemon info: %w", err)
+	}
+
+	return info.OSType + "/" + normalizeArch(info.Architecture), nil
 }
 
-// GetDockerHubRepoTags returns all tags for the given docker hub repository. The resulting list gets sorted in
-// ascending order. Currently, the default behavior is to only return tags that match the pattern \d+\.\d+.
+// normalizeArch maps the kernel architecture names reported by the daemon to the GOARCH-style names used in platform
+// strings.
+func normalizeArch(arch string) string {
+	switch arch {
+	case "x86_64", "x86-64":
+		return "amd64"
+	case "aarch64":
+		return "arm64"
+	case "armv7l", "armhf":
+		return "arm"
+	case "i386", "i686":
+		return "386"
+	default:
+		return arch
+	}
+}
+
+// GetDockerHubRepoTags returns the names of all tags of the given docker hub repository; either an official image,
+// like postgres, or a namespaced one, like bitnami/postgresql. The names are returned in the order docker hub lists
+// them, neither sorted nor filtered; e.g. "latest" and "16-alpine" are included.
 func GetDockerHubRepoTags(ctx context.Context, repo string) ([]string, error) {
-	return getAllTags(ctx, repo)
+	tags, err := getAllTags(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+
+	return names, nil
 }
 
 // Login logs in to the docker registry using the given auth config. It uses the docker CLI to login.
@@ -98,15 +342,15 @@ func (c *Client) Login(ctx context.Context, auth registry.AuthConfig) error {
 
 	logger.Debug("Verifying docker login")
 	if auth.Username == "" || auth.Password == "" {
-		return fmt.Errorf("docker login failed: username or password is empty")
+		return fmt.Errorf("%w: username or password is empty", ErrAuthFailed)
 	}
 
 	logger.Debugf("Logging in to docker registry as %s", auth.Username)
 
 	// Registry Login
-	authResponse, err := c.dockerClient.RegistryLogin(ctx, auth)
+	authResponse, err := c.GetDockerClient().RegistryLogin(ctx, auth)
 	if err != nil {
-		return fmt.Errorf("login to docker registry: %w", err)
+		return fmt.Errorf("login to docker registry: %w: %w", ErrAuthFailed, err)
 	}
 
 	logger.Debugf("login response: %+v", authResponse)
@@ -136,6 +380,107 @@ func (c *Client) Login(ctx context.Context, auth registry.AuthConfig) error {
 	return nil
 }
 
+// dockerHubHosts are the different names under which Docker Hub appears in auth configs.
+var dockerHubHosts = []string{"docker.io", "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com"}
+
+type authConfigFile struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+}
+
+// normalizeRegistryHost strips the scheme and path from a registry address, e.g. https://index.docker.io/v1/ becomes
+// index.docker.io, and maps all Docker Hub aliases to docker.io.
+func normalizeRegistryHost(address string) string {
+	address = strings.TrimPrefix(address, "https://")
+	address = strings.TrimPrefix(address, "http://")
+	address, _, _ = strings.Cut(address, "/")
+
+	if slices.Contains(dockerHubHosts, address) {
+		return "docker.io"
+	}
+
+	return address
+}
+
+// RegistryHost returns the registry host of the given repository or image reference; e.g. ghcr.io for
+// ghcr.io/me/repo. Repositories without an explicit registry, like johndoe/repo, live on docker.io.
+func RegistryHost(repo string) string {
+	host, _, found := strings.Cut(repo, "/")
+	if !found || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		return "docker.io"
+	}
+
+	return normalizeRegistryHost(host)
+}
+
+// LoginFromAuthConfig logs in to the given registry using its entry in a docker config.json-style auth config, like
+// the DOCKER_AUTH_CONFIG variable used by GitLab CI. The config may be plain or base64 encoded JSON. Both identity
+// token and basic auth entries are supported.
+func (c *Client) LoginFromAuthConfig(ctx context.Context, config []byte, registryHost string) error {
+	logger := simplog.FromContext(ctx)
+
+	config = bytes.TrimSpace(config)
+	if !bytes.HasPrefix(config, []byte("{")) {
+		decoded, err := base64.StdEncoding.DecodeString(string(config))
+		if err != nil {
+			return fmt.Errorf("decode auth config: %w", err)
+		}
+		config = decoded
+	}
+
+	var file authConfigFile
+	if err := json.Unmarshal(config, &file); err != nil {
+		return fmt.Errorf("parse auth config: %w", err)
+	}
+
+	registryHost = normalizeRegistryHost(registryHost)
+	for address, entry := range file.Auths {
+		if normalizeRegistryHost(address) != registryHost {
+			continue
+		}
+
+		logger.Debugf("Using auth config entry %q", address)
+
+		// Identity tokens are passed on as is; there's no need to log in with them
+		if entry.IdentityToken != "" {
+			token, err := registry.EncodeAuthConfig(registry.AuthConfig{
+				IdentityToken: entry.IdentityToken,
+				ServerAddress: address,
+			})
+			if err != nil {
+				return fmt.Errorf("encode auth config: %w", err)
+			}
+
+			c.authToken = token
+
+			return nil
+		}
+
+		// Basic auth; the credentials are either given explicitly or as base64 encoded "username:password"
+		username, password := entry.Username, entry.Password
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return fmt.Errorf("decode auth of %q: %w", address, err)
+			}
+
+			var found bool
+			username, password, found = strings.Cut(string(decoded), ":")
+			if !found {
+				return fmt.Errorf("invalid auth of %q: expected username:password", address)
+			}
+		}
+
+		return c.Login(ctx, registry.AuthConfig{Username: username, Password: password, ServerAddress: address})
+	}
+
+	return fmt.Errorf("no auth config entry found for registry %q", registryHost)
+}
+
 // LoginBasic logs in to the docker registry using the given username and password. It calls the Login method internally.
 func (c *Client) LoginBasic(ctx context.Context, username, password string) error {
 	return c.Login(ctx, registry.AuthConfig{Username: username, Password: password})
@@ -159,5 +504,5 @@ func (c *Client) Logout(ctx context.Context) error {
 
 // Close closes the client.
 func (c *Client) Close(_ context.Context) error {
-	return c.dockerClient.Close()
+	return c.GetDockerClient().Close()
 }
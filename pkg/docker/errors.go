@@ -0,0 +1,69 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrDaemonUnreachable is returned if the docker daemon can't be reached.
+	ErrDaemonUnreachable = errors.New("docker daemon unreachable")
+	// ErrAuthFailed is returned if logging in to a registry failed.
+	ErrAuthFailed = errors.New("registry authentication failed")
+	// ErrRepoNotFound is returned if a repository doesn't exist in the registry.
+	ErrRepoNotFound = errors.New("repository not found")
+	// ErrBuildFailed matches every BuildError.
+	ErrBuildFailed = errors.New("build failed")
+	// ErrPushFailed matches every PushError.
+	ErrPushFailed = errors.New("push failed")
+	// ErrTagImmutable is returned if the registry refused to overwrite an existing tag, e.g. ECR with tag immutability.
+	ErrTagImmutable = errors.New("tag is immutable")
+)
+
+type (
+	// BuildError is returned if an image could not be built. It matches ErrBuildFailed.
+	BuildError struct {
+		Tag string
+		Err error
+	}
+
+	// PushError is returned if an image could not be pushed. It matches ErrPushFailed.
+	PushError struct {
+		Tag string
+		Err error
+	}
+)
+
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("build image %s: %v", e.Tag, e.Err)
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+func (e *BuildError) Is(target error) bool {
+	return target == ErrBuildFailed
+}
+
+func (e *PushError) Error() string {
+	return fmt.Sprintf("push image %s: %v", e.Tag, e.Err)
+}
+
+func (e *PushError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PushError) Is(target error) bool {
+	return target == ErrPushFailed
+}
+
+// isImmutableTagError reports whether the given push error was caused by the registry refusing to overwrite an existing
+// tag. Registries word this differently; e.g. ECR reports "tag invalid: The image tag '16.1' already exists in the 'x'
+// repository and cannot be overwritten because the repository is immutable".
+func isImmutableTagError(err error) bool {
+	message := strings.ToLower(err.Error())
+
+	return strings.Contains(message, "immutable") || strings.Contains(message, "cannot be overwritten")
+}
@@ -1,19 +1,33 @@
 package docker
 
 import (
+	"archive/tar"
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/pkg/archive"
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
 	"github.com/nikoksr/simplog"
 	"github.com/rs/xid"
+	"golang.org/x/sync/errgroup"
 )
 
 type ErrorDetail struct {
@@ -25,7 +39,25 @@ type ErrorLine struct {
 	ErrorDetail ErrorDetail `json:"errorDetail"`
 }
 
-func (c *imageClient) getImageIDAndBaseID(ctx context.Context, imageRef string) (string, string, error) {
+type streamLine struct {
+	Stream string          `json:"stream"`
+	ID     string          `json:"id"`
+	Aux    json.RawMessage `json:"aux"`
+	ErrorLine
+}
+
+type pushAux struct {
+	Aux *struct {
+		Tag    string `json:"Tag"`
+		Digest string `json:"Digest"`
+	} `json:"aux"`
+}
+
+var loadedImagePrefixes = []string{"Loaded image: ", "Loaded image ID: "}
+
+// getImageIDAndBaseID returns the IDs of the given image and its base image. The base reference, if set, is the image
+// the built image's final stage was built on; see getBaseID.
+func (c *imageClient) getImageIDAndBaseID(ctx context.Context, imageRef, baseRef string) (string, string, error) {
 	client := c.provider.GetDockerClient()
 
 	// Get image id
@@ -43,12 +75,45 @@ func (c *imageClient) getImageIDAndBaseID(ctx context.Context, imageRef string)
 	// Trim the sha256: prefix from the image id
 	imageID := strings.TrimPrefix(imageList[0].ID, "sha256:")
 
-	// Get base image id. The base image ID is the last entry in the image history that is not <missing>.
+	// Get base image id
+	baseID, err := c.getBaseID(ctx, imageID, baseRef)
+	if err != nil {
+		return "", "", fmt.Errorf("get base image id of %q: %w", imageRef, err)
+	}
+
+	return imageID, baseID, nil
+}
+
+// getBaseID returns the ID of the image the given image was built on, using the client's base detection strategy. With
+// the history strategy, the given base reference is used for images whose history only knows the image itself.
+func (c *imageClient) getBaseID(ctx context.Context, imageID, baseRef string) (string, error) {
+	switch c.baseStrategy {
+	case BaseStrategyHistory, "":
+		baseID, err := c.getBaseIDFromHistory(ctx, imageID)
+		// BuildKit doesn't record the parent of the images it builds; match the layers against the base image instead
+		if err == nil && baseID == imageID && baseRef != "" {
+			return c.getBaseIDFromLayers(ctx, imageID, baseRef)
+		}
+
+		return baseID, err
+	case BaseStrategyFromLabel:
+		return c.getBaseIDFromLabel(ctx, imageID)
+	case BaseStrategyInspectDigest:
+		return c.getBaseIDFromDigest(ctx, imageID)
+	default:
+		return "", fmt.Errorf("unknown base detection strategy %q", c.baseStrategy)
+	}
+}
+
+// getBaseIDFromHistory returns the last entry in the image history that is not <missing>. This is a heuristic; it
+// works for images built locally on top of a pulled image.
+func (c *imageClient) getBaseIDFromHistory(ctx context.Context, imageID string) (string, error) {
+	client := c.provider.GetDockerClient()
 
 	// Get image history
 	imageHistory, err := client.ImageHistory(ctx, imageID)
 	if err != nil {
-		return "", "", fmt.Errorf("get image history: %w", err)
+		return "", fmt.Errorf("get image history: %w", err)
 	}
 
 	// Find the base image id
@@ -62,39 +127,501 @@ func (c *imageClient) getImageIDAndBaseID(ctx context.Context, imageRef string)
 	}
 
 	if baseID == "" {
-		return "", "", fmt.Errorf("could not find base image id for %q", imageRef)
+		return "", errors.New("no base image found in image history")
 	}
 
-	return imageID, baseID, nil
+	return baseID, nil
 }
 
-// Build builds a docker image from a dockerfile. It returns the image ID and an error. It calls the docker cli command.
-// The build command is run with BuildKit enabled.
-func (c *imageClient) Build(ctx context.Context, buildDir string, tags ...string) (string, string, error) {
-	logger := simplog.FromContext(ctx)
+// getBaseIDFromLayers returns the ID of the local image with the given reference, if its layers are the bottom layers
+// of the given image.
+func (c *imageClient) getBaseIDFromLayers(ctx context.Context, imageID, baseRef string) (string, error) {
+	info, err := c.Inspect(ctx, imageID)
+	if err != nil {
+		return "", err
+	}
+
+	base, err := c.Inspect(ctx, baseRef)
+	if err != nil {
+		return "", fmt.Errorf("resolve base image %s: %w", baseRef, err)
+	}
+
+	if !hasLayerPrefix(info.RootFS.Layers, base.RootFS.Layers) {
+		return "", fmt.Errorf("image isn't built on %s; the tag might have moved during the build", baseRef)
+	}
+
+	return strings.TrimPrefix(base.ID, "sha256:"), nil
+}
+
+// getBaseIDFromLabel resolves the base image from the reference in the org.opencontainers.image.base.name label.
+func (c *imageClient) getBaseIDFromLabel(ctx context.Context, imageID string) (string, error) {
+	info, err := c.Inspect(ctx, imageID)
+	if err != nil {
+		return "", err
+	}
+
+	baseRef := labelValue(info, LabelBaseName)
+	if baseRef == "" {
+		return "", fmt.Errorf("image has no %s label", LabelBaseName)
+	}
+
+	baseInfo, err := c.Inspect(ctx, baseRef)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(baseInfo.ID, "sha256:"), nil
+}
+
+// getBaseIDFromDigest resolves the base image by matching the digest in the org.opencontainers.image.base.digest label
+// against the repo digests of the local images.
+func (c *imageClient) getBaseIDFromDigest(ctx context.Context, imageID string) (string, error) {
 	client := c.provider.GetDockerClient()
 
-	if len(tags) == 0 {
-		return "", "", errors.New("no tags provided")
+	info, err := c.Inspect(ctx, imageID)
+	if err != nil {
+		return "", err
 	}
 
-	// Create Build Context
-	buildContext, err := archive.TarWithOptions(buildDir, &archive.TarOptions{
-		IncludeFiles: []string{"."},
-	})
+	digest := labelValue(info, LabelBaseDigest)
+	if digest == "" {
+		return "", fmt.Errorf("image has no %s label", LabelBaseDigest)
+	}
+
+	imageList, err := client.ImageList(ctx, image.ListOptions{})
 	if err != nil {
-		return "", "", fmt.Errorf("create build context: %w", err)
+		return "", fmt.Errorf("list images: %w", err)
+	}
+
+	for _, summary := range imageList {
+		for _, repoDigest := range summary.RepoDigests {
+			if strings.HasSuffix(repoDigest, "@"+digest) {
+				return strings.TrimPrefix(summary.ID, "sha256:"), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no local image with digest %s found", digest)
+}
+
+func labelValue(info types.ImageInspect, label string) string {
+	if info.Config == nil {
+		return ""
+	}
+
+	return info.Config.Labels[label]
+}
+
+// readIgnorePatterns returns the patterns of the build directory's .dockerignore and the given external ignore file,
+// if any. Like the docker CLI, it never excludes the Dockerfile, which the daemon needs.
+func readIgnorePatterns(buildDir, externalPath string) ([]string, error) {
+	local, err := os.ReadFile(filepath.Join(buildDir, ".dockerignore"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read ignore file: %w", err)
+	}
+
+	return ignorePatterns(local, externalPath)
+}
+
+// ignorePatterns returns the patterns of the given .dockerignore content and the given external ignore file, if any.
+// Like the docker CLI, it never excludes the Dockerfile, which the daemon needs.
+func ignorePatterns(local []byte, externalPath string) ([]string, error) {
+	patterns, err := ignorefile.ReadAll(bytes.NewReader(local))
+	if err != nil {
+		return nil, fmt.Errorf("read ignore file .dockerignore: %w", err)
+	}
+
+	if externalPath != "" {
+		file, err := os.Open(externalPath)
+		if err != nil {
+			return nil, fmt.Errorf("open ignore file: %w", err)
+		}
+
+		filePatterns, err := ignorefile.ReadAll(file)
+		_ = file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read ignore file %s: %w", externalPath, err)
+		}
+
+		patterns = append(patterns, filePatterns...)
+	}
+
+	if len(patterns) > 0 {
+		patterns = append(patterns, "!Dockerfile")
+	}
+
+	return patterns, nil
+}
+
+// tarFiles writes the given files, keyed by their slash-separated path, into an in-memory tar archive, leaving out the
+// files excluded by the given ignore patterns. All files get the given modification time.
+func tarFiles(files map[string][]byte, excludes []string, modTime time.Time) (*bytes.Buffer, error) {
+	matcher, err := patternmatcher.New(excludes)
+	if err != nil {
+		return nil, fmt.Errorf("parse ignore patterns: %w", err)
+	}
+
+	// Sort the names, so the same files always result in the same archive
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for _, name := range names {
+		excluded, err := matcher.MatchesOrParentMatches(name)
+		if err != nil {
+			return nil, fmt.Errorf("match ignore patterns: %w", err)
+		}
+		if excluded {
+			continue
+		}
+
+		header := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name])), ModTime: modTime, Typeflag: tar.TypeReg}
+		if err = writer.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("write header of %s: %w", name, err)
+		}
+
+		if _, err = writer.Write(files[name]); err != nil {
+			return nil, fmt.Errorf("write %s: %w", name, err)
+		}
+	}
+
+	if err = writer.Close(); err != nil {
+		return nil, fmt.Errorf("close archive: %w", err)
+	}
+
+	return &buf, nil
+}
+
+// vcsPatterns are the ignore patterns of version control directories.
+var vcsPatterns = []string{"**/.git", "**/.hg", "**/.svn"}
+
+// contextExcludes returns the given ignore patterns extended by the ones requested through the build options.
+func contextExcludes(excludes []string, opts BuildOptions) []string {
+	if !opts.ContextExcludeVCS {
+		return excludes
+	}
+
+	return append(slices.Clone(excludes), vcsPatterns...)
+}
+
+// compressContext gzips the given build context with the given level, streaming it as the daemon reads it. A level of
+// zero returns the context as is. Closing the returned reader closes the given one and stops the compression.
+func compressContext(buildContext io.ReadCloser, level int) (io.ReadCloser, error) {
+	if level == 0 {
+		return buildContext, nil
+	}
+
+	if level < gzip.BestSpeed || level > gzip.BestCompression {
+		return nil, fmt.Errorf("invalid build context compression level %d; expected 0 to 9", level)
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		defer buildContext.Close()
+
+		compressor, _ := gzip.NewWriterLevel(writer, level)
+		_, err := io.Copy(compressor, buildContext)
+		if closeErr := compressor.Close(); err == nil {
+			err = closeErr
+		}
+
+		_ = writer.CloseWithError(err)
+	}()
+
+	return reader, nil
+}
+
+// cpuPeriod is the CFS period in microseconds used to express CPU limits of builds as quota.
+const cpuPeriod = 100000
+
+// newImageBuildOptions translates the given options into the options of the docker API.
+func newImageBuildOptions(opts BuildOptions, tags []string) types.ImageBuildOptions {
+	buildArgs := make(map[string]*string, len(opts.BuildArgs)+1)
+	for key, value := range opts.BuildArgs {
+		value := value
+		buildArgs[key] = &value
+	}
+
+	// An explicitly passed build arg takes precedence
+	if _, ok := buildArgs[BuildArgSourceDateEpoch]; !ok && !opts.SourceDateEpoch.IsZero() {
+		epoch := strconv.FormatInt(opts.SourceDateEpoch.Unix(), 10)
+		buildArgs[BuildArgSourceDateEpoch] = &epoch
 	}
 
-	// Build Configuration
 	buildOptions := types.ImageBuildOptions{
 		Dockerfile: "Dockerfile",
 		Tags:       tags,
-		BuildArgs:  map[string]*string{},
+		BuildArgs:  buildArgs,
+		Labels:     opts.Labels,
 		BuildID:    xid.New().String(),
 		Remove:     true,
-		// FIXME: Enabling BuildKit causes the build to fail
-		// Version: types.BuilderBuildKit,
+		Platform:   opts.Platform,
+		Memory:     opts.Memory,
+		Ulimits:    opts.Ulimits,
+	}
+
+	// The build API has no equivalent of --cpus; express it as CFS quota, the same way the docker CLI does for
+	// containers.
+	if opts.CPUs > 0 {
+		buildOptions.CPUPeriod = cpuPeriod
+		buildOptions.CPUQuota = int64(opts.CPUs * cpuPeriod)
+	}
+
+	return buildOptions
+}
+
+// BuildCommand returns the docker CLI command equivalent to building the given directory with Build.
+func BuildCommand(buildDir string, opts BuildOptions, tags ...string) []string {
+	buildOptions := newImageBuildOptions(opts, tags)
+
+	command := []string{"docker", "build"}
+	for _, tag := range buildOptions.Tags {
+		command = append(command, "--tag", tag)
+	}
+
+	if buildOptions.Platform != "" {
+		command = append(command, "--platform", buildOptions.Platform)
+	}
+
+	buildArgs := make([]string, 0, len(buildOptions.BuildArgs))
+	for key, value := range buildOptions.BuildArgs {
+		if value == nil {
+			buildArgs = append(buildArgs, key)
+		} else {
+			buildArgs = append(buildArgs, key+"="+*value)
+		}
+	}
+	sort.Strings(buildArgs)
+
+	for _, buildArg := range buildArgs {
+		command = append(command, "--build-arg", buildArg)
+	}
+
+	if buildOptions.Memory > 0 {
+		command = append(command, "--memory", strconv.FormatInt(buildOptions.Memory, 10))
+	}
+
+	if buildOptions.CPUQuota > 0 {
+		command = append(command,
+			"--cpu-period", strconv.FormatInt(buildOptions.CPUPeriod, 10),
+			"--cpu-quota", strconv.FormatInt(buildOptions.CPUQuota, 10),
+		)
+	}
+
+	for _, ulimit := range buildOptions.Ulimits {
+		command = append(command, "--ulimit", ulimit.String())
+	}
+
+	if opts.ContextCompression > 0 {
+		command = append(command, "--compress")
+	}
+
+	for _, secret := range opts.Secrets {
+		if secret.Src != "" {
+			command = append(command, "--secret", "id="+secret.ID+",src="+secret.Src)
+		} else {
+			command = append(command, "--secret", "id="+secret.ID+",env="+secret.Env)
+		}
+	}
+
+	for _, agent := range opts.SSH {
+		if len(agent.Paths) == 0 {
+			command = append(command, "--ssh", agent.ID)
+		} else {
+			command = append(command, "--ssh", agent.ID+"="+strings.Join(agent.Paths, ","))
+		}
+	}
+
+	for _, output := range buildKitOutputs(opts) {
+		attrs := []string{"type=" + output.Type}
+		for key, value := range output.Attrs {
+			attrs = append(attrs, key+"="+value)
+		}
+		sort.Strings(attrs[1:])
+
+		command = append(command, "--output", strings.Join(attrs, ","))
+	}
+
+	if opts.Provenance != "" {
+		command = append(command, "--provenance", "mode="+opts.Provenance)
+	}
+
+	labels := make([]string, 0, len(buildOptions.Labels))
+	for key, value := range buildOptions.Labels {
+		labels = append(labels, key+"="+value)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		command = append(command, "--label", label)
+	}
+
+	return append(command, "--file", filepath.Join(buildDir, buildOptions.Dockerfile), buildDir)
+}
+
+// PushCommand returns the docker CLI command equivalent to pushing the given image with Push.
+func PushCommand(ref string) []string {
+	return []string{"docker", "push", ref}
+}
+
+// TagCommand returns the docker CLI command equivalent to Tag for a single tag.
+func TagCommand(source, tag string) []string {
+	return []string{"docker", "tag", source, tag}
+}
+
+// Build builds a docker image from a dockerfile. It returns the image ID and an error. It calls the docker cli command.
+// The build command is run with BuildKit enabled.
+func (c *imageClient) Build(ctx context.Context, buildDir string, opts BuildOptions, tags ...string) (string, string, error) {
+	if len(tags) == 0 {
+		return "", "", errors.New("no tags provided")
+	}
+
+	// Create Build Context
+	excludes, err := readIgnorePatterns(buildDir, opts.DockerignorePath)
+	if err != nil {
+		return "", "", err
+	}
+
+	// The Dockerfile is only needed to find the base image; a missing one fails the build itself
+	dockerfile, _ := os.ReadFile(filepath.Join(buildDir, "Dockerfile"))
+
+	// The build context is streamed to the daemon, so every attempt needs a fresh one
+	newContext := func() (io.ReadCloser, error) {
+		archived, err := archive.TarWithOptions(buildDir, &archive.TarOptions{
+			IncludeFiles:    []string{"."},
+			ExcludePatterns: contextExcludes(excludes, opts),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create build context: %w", err)
+		}
+
+		buildContext, err := compressContext(archived, opts.ContextCompression)
+		if err != nil {
+			_ = archived.Close()
+			return nil, err
+		}
+
+		return buildContext, nil
+	}
+
+	var imageID, baseID string
+	err = c.provider.retryOnDisconnect(ctx, func() error {
+		var err error
+		imageID, baseID, err = c.buildWithFallback(ctx, newContext, dockerfile, opts, tags)
+
+		return err
+	})
+
+	return imageID, baseID, err
+}
+
+// BuildFiles builds a docker image from the given files, keyed by their slash-separated path in the build context,
+// e.g. "Dockerfile". The build context is created in memory, so small contexts don't need a build directory. Ignore
+// patterns apply as with Build, with a ".dockerignore" file taking the place of the build directory's one.
+func (c *imageClient) BuildFiles(ctx context.Context, files map[string][]byte, opts BuildOptions, tags ...string) (string, string, error) {
+	if len(tags) == 0 {
+		return "", "", errors.New("no tags provided")
+	}
+
+	excludes, err := ignorePatterns(files[".dockerignore"], opts.DockerignorePath)
+	if err != nil {
+		return "", "", err
+	}
+
+	archived, err := tarFiles(files, contextExcludes(excludes, opts), opts.SourceDateEpoch)
+	if err != nil {
+		return "", "", fmt.Errorf("create build context: %w", err)
+	}
+
+	newContext := func() (io.ReadCloser, error) {
+		return compressContext(io.NopCloser(bytes.NewReader(archived.Bytes())), opts.ContextCompression)
+	}
+
+	var imageID, baseID string
+	err = c.provider.retryOnDisconnect(ctx, func() error {
+		var err error
+		imageID, baseID, err = c.buildWithFallback(ctx, newContext, files["Dockerfile"], opts, tags)
+
+		return err
+	})
+
+	return imageID, baseID, err
+}
+
+// buildWithFallback builds a docker image with the client's build backend from a build context returned by
+// newContext. If BuildKit was picked automatically but didn't start the build, it's retried with the classic builder.
+func (c *imageClient) buildWithFallback(
+	ctx context.Context, newContext func() (io.ReadCloser, error), dockerfile []byte, opts BuildOptions, tags []string,
+) (string, string, error) {
+	backend := c.buildBackend
+	for {
+		buildContext, err := newContext()
+		if err != nil {
+			return "", "", err
+		}
+
+		imageID, baseID, err := c.build(ctx, buildContext, backend, dockerfile, opts, tags)
+		_ = buildContext.Close()
+
+		// Connection errors are retried as they are, once the daemon is back. Builds using secrets or SSH agents
+		// can't fall back, the classic builder doesn't support them.
+		if backend == BuildBackendBuildKit && c.buildKitFallback && !needsBuildKit(opts) &&
+			errors.Is(err, errBuildKitNotStarted) && !isConnectionError(err) {
+			simplog.FromContext(ctx).Warnf("BuildKit didn't start the build of %s, retrying with the classic builder: %v", tags[0], err)
+			backend = BuildBackendClassic
+
+			continue
+		}
+
+		return imageID, baseID, err
+	}
+}
+
+// build builds a docker image from the given build context with the given backend and returns the IDs of the image and
+// its base image. The Dockerfile is the one in the build context.
+func (c *imageClient) build(
+	ctx context.Context, buildContext io.Reader, backend BuildBackend, dockerfile []byte, opts BuildOptions, tags []string,
+) (string, string, error) {
+	logger := simplog.FromContext(ctx)
+	client := c.provider.GetDockerClient()
+
+	// Build Configuration. BuildKit gets the build context as request body, like the classic builder; the session
+	// is how it calls back into the client.
+	buildOptions := newImageBuildOptions(opts, tags)
+	if backend != BuildBackendBuildKit && needsBuildKit(opts) {
+		return "", "", &BuildError{Tag: tags[0], Err: errors.New("secrets, SSH forwarding, OCI media types and attestations need BuildKit")}
+	}
+
+	var baseRef string
+	if backend == BuildBackendBuildKit {
+		s, err := c.startBuildKitSession(ctx, opts)
+		if err != nil {
+			return "", "", &BuildError{Tag: tags[0], Err: fmt.Errorf("%w: %w", errBuildKitNotStarted, err)}
+		}
+		defer func() { _ = s.Close() }()
+
+		buildOptions.Version = types.BuilderBuildKit
+		buildOptions.SessionID = s.ID()
+		buildOptions.Outputs = buildKitOutputs(opts)
+		for key, value := range buildKitAttestations(opts) {
+			value := value
+			buildOptions.BuildArgs[key] = &value
+		}
+
+		// BuildKit pulls base images into its own cache, so they don't show up in the history of the built image. Pull
+		// the base image into the image store, to match the built image against it.
+		if c.baseStrategy == BaseStrategyHistory || c.baseStrategy == "" {
+			baseRef = finalBaseImage(dockerfile)
+			if baseRef != "" {
+				if err = c.pullImage(ctx, baseRef, opts.Platform); err != nil {
+					return "", "", &BuildError{Tag: tags[0], Err: fmt.Errorf("pull base image %s: %w", baseRef, err)}
+				}
+			}
+		}
 	}
 
 	// Build Image
@@ -102,11 +629,30 @@ func (c *imageClient) Build(ctx context.Context, buildDir string, tags ...string
 
 	buildResponse, err := client.ImageBuild(ctx, buildContext, buildOptions)
 	if err != nil {
-		return "", "", fmt.Errorf("build image: %w", err)
+		if backend == BuildBackendBuildKit {
+			err = fmt.Errorf("%w: %w", errBuildKitNotStarted, err)
+		}
+
+		return "", "", &BuildError{Tag: tags[0], Err: err}
 	}
 
-	// Parse the build output for errors
+	// Time the build steps as they pass by
+	timer := &stepTimer{}
+	stepDone := func(step *BuildStep) {
+		if step == nil {
+			return
+		}
+
+		logger.Debugf("Step %d (%s) took %s", step.Number, step.Instruction, step.Duration.Round(time.Millisecond))
+		if opts.OnStep != nil {
+			opts.OnStep(*step)
+		}
+	}
+
+	// Parse the build output for errors. BuildKit reports its progress in trace messages instead of stream lines.
 	errLines := make([]string, 0)
+	var traceErrLines []string
+	tracer := newBuildKitTracer()
 	scanner := bufio.NewScanner(buildResponse.Body)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -115,27 +661,64 @@ func (c *imageClient) Build(ctx context.Context, buildDir string, tags ...string
 		}
 
 		// Parse each line and look for errors
-		errLine := &ErrorLine{}
-		if err := json.Unmarshal([]byte(line), errLine); err == nil && errLine.Error != "" {
-			errLines = append(errLines, errLine.ErrorDetail.Message)
+		streamed := &streamLine{}
+		if err := json.Unmarshal([]byte(line), streamed); err != nil {
+			continue
+		}
+
+		if streamed.Error != "" {
+			errLines = append(errLines, streamed.ErrorDetail.Message)
+		}
+
+		if streamed.ID == auxBuildKitTrace {
+			steps, vertexErrLines, err := tracer.observe(streamed.Aux)
+			if err != nil {
+				logger.Debugf("Skipping BuildKit trace message: %v", err)
+				continue
+			}
+
+			for i := range steps {
+				stepDone(&steps[i])
+			}
+			traceErrLines = append(traceErrLines, vertexErrLines...)
+
+			continue
 		}
+
+		stepDone(timer.observe(streamed.Stream))
 	}
+	stepDone(timer.finish())
 
 	// Close the build response body
 	_ = buildResponse.Body.Close()
 
+	// A read error means the connection to the daemon dropped before the build finished
+	if err = scanner.Err(); err != nil {
+		return "", "", &BuildError{Tag: tags[0], Err: fmt.Errorf("read build output: %w", err)}
+	}
+
 	prettyBuildResponse, _ := json.MarshalIndent(buildResponse, "", "  ")
 	logger.Debugf("Build response: %s", string(prettyBuildResponse))
 
-	// Check if any errors were captured during build
+	// Check if any errors were captured during build. The errors of failed BuildKit steps are only needed if the
+	// daemon didn't report the failure itself.
+	if len(errLines) == 0 {
+		errLines = traceErrLines
+	}
+
 	if len(errLines) > 0 {
-		return "", "", fmt.Errorf("build image: %w", errors.New(strings.Join(errLines, "; ")))
+		err = errors.New(strings.Join(errLines, "; "))
+		if backend == BuildBackendBuildKit && !tracer.started {
+			err = fmt.Errorf("%w: %w", errBuildKitNotStarted, err)
+		}
+
+		return "", "", &BuildError{Tag: tags[0], Err: err}
 	}
 
 	logger.Debugf("Build finished for %v", tags)
 
 	// Get image id
-	imageID, parentID, err := c.getImageIDAndBaseID(ctx, tags[0])
+	imageID, parentID, err := c.getImageIDAndBaseID(ctx, tags[0], baseRef)
 	if err != nil {
 		return "", "", fmt.Errorf("get image id: %w", err)
 	}
@@ -143,60 +726,314 @@ func (c *imageClient) Build(ctx context.Context, buildDir string, tags ...string
 	return imageID, parentID, nil
 }
 
-// Push pushes a docker image to a registry. It calls the docker cli command.
-func (c *imageClient) Push(ctx context.Context, images ...string) error {
+// Push pushes a docker image to a registry. It returns the manifest digest reported by the registry for each pushed
+// reference. It calls the docker cli command. Pushes that hit the registry's rate limit are retried with a growing
+// delay, which also paces the following pushes.
+func (c *imageClient) Push(ctx context.Context, images ...string) (map[string]string, error) {
 	logger := simplog.FromContext(ctx)
-	client := c.provider.GetDockerClient()
-	authToken := c.provider.GetAuthToken()
 
+	digests := make(map[string]string, len(images))
 	for _, imageRef := range images {
-		err := func() error {
-			logger.Debugf("Pushing image %q", imageRef)
-
-			options := image.PushOptions{
-				RegistryAuth: authToken,
+		for attempt := 1; ; attempt++ {
+			if err := c.pacer.wait(ctx); err != nil {
+				return nil, &PushError{Tag: imageRef, Err: err}
 			}
-			response, err := client.ImagePush(ctx, imageRef, options)
-			if err != nil {
+
+			var digest string
+			err := c.provider.retryOnDisconnect(ctx, func() error {
+				var err error
+				digest, err = c.pushImage(ctx, imageRef)
 				return err
+			})
+			if err == nil {
+				c.pacer.succeeded()
+				digests[imageRef] = digest
+				break
 			}
-			defer response.Close()
 
-			scanner := bufio.NewScanner(response)
-			for scanner.Scan() {
-				line := scanner.Text()
-				logger.Debug(line)
+			if isImmutableTagError(err) {
+				return nil, &PushError{Tag: imageRef, Err: fmt.Errorf("%w: %v", ErrTagImmutable, err)}
 			}
 
-			return nil
-		}()
-		if err != nil {
-			return err
+			if !isRateLimitError(err) || attempt == maxPushAttempts {
+				return nil, &PushError{Tag: imageRef, Err: err}
+			}
+
+			delay := c.pacer.limited()
+			logger.Debugf("Push of %q rate limited (attempt %d/%d); waiting %s: %v", imageRef, attempt, maxPushAttempts, delay, err)
+		}
+	}
+
+	return digests, nil
+}
+
+// pushImage pushes a single reference and returns the manifest digest reported by the registry. Errors reported in the
+// push stream, e.g. a denied access or a rate limit, are returned as errors.
+func (c *imageClient) pushImage(ctx context.Context, imageRef string) (string, error) {
+	logger := simplog.FromContext(ctx)
+	client := c.provider.GetDockerClient()
+
+	logger.Debugf("Pushing image %q", imageRef)
+
+	options := image.PushOptions{
+		RegistryAuth: c.provider.GetAuthToken(),
+	}
+	response, err := client.ImagePush(ctx, imageRef, options)
+	if err != nil {
+		return "", err
+	}
+	defer response.Close()
+
+	var digest string
+	var errLines []string
+	scanner := bufio.NewScanner(response)
+	for scanner.Scan() {
+		line := scanner.Text()
+		logger.Debug(line)
+
+		// The digest of the pushed manifest is reported in an aux message
+		aux := &pushAux{}
+		if err := json.Unmarshal([]byte(line), aux); err == nil && aux.Aux != nil && aux.Aux.Digest != "" {
+			digest = aux.Aux.Digest
+		}
+
+		errLine := &ErrorLine{}
+		if err := json.Unmarshal([]byte(line), errLine); err == nil && errLine.Error != "" {
+			errLines = append(errLines, errLine.Error)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return "", fmt.Errorf("read push output: %w", err)
+	}
+
+	if len(errLines) > 0 {
+		return "", errors.New(strings.Join(errLines, "; "))
+	}
+
+	return digest, nil
+}
+
+// pullImage pulls the given image for the given platform, or the daemon's native one if empty. Errors reported in the
+// pull stream are returned as errors.
+func (c *imageClient) pullImage(ctx context.Context, ref, platform string) error {
+	logger := simplog.FromContext(ctx)
+	client := c.provider.GetDockerClient()
+
+	logger.Debugf("Pulling image %q", ref)
+
+	response, err := client.ImagePull(ctx, ref, image.PullOptions{Platform: platform})
+	if err != nil {
+		return err
+	}
+	defer response.Close()
+
+	var errLines []string
+	scanner := bufio.NewScanner(response)
+	for scanner.Scan() {
+		errLine := &ErrorLine{}
+		if err := json.Unmarshal(scanner.Bytes(), errLine); err == nil && errLine.Error != "" {
+			errLines = append(errLines, errLine.Error)
 		}
 	}
 
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("read pull output: %w", err)
+	}
+
+	if len(errLines) > 0 {
+		return errors.New(strings.Join(errLines, "; "))
+	}
+
 	return nil
 }
 
-// Remove removes one or more docker images. It returns an error if one of the images could not be removed. It uses
-// the docker API.
-func (c *imageClient) Remove(ctx context.Context, ids ...string) error {
+// Tag adds the given tags to the source image. It is the API equivalent of docker tag.
+func (c *imageClient) Tag(ctx context.Context, source string, tags ...string) error {
+	logger := simplog.FromContext(ctx)
+	client := c.provider.GetDockerClient()
+
+	for _, tag := range tags {
+		logger.Debugf("Tagging image %q as %q", source, tag)
+		if err := client.ImageTag(ctx, source, tag); err != nil {
+			return fmt.Errorf("tag image %q as %q: %w", source, tag, err)
+		}
+	}
+
+	return nil
+}
+
+// Untag removes the given tags from their images, without removing the images themselves.
+func (c *imageClient) Untag(ctx context.Context, tags ...string) error {
+	logger := simplog.FromContext(ctx)
+	client := c.provider.GetDockerClient()
+
+	for _, tag := range tags {
+		logger.Debugf("Untagging %q", tag)
+		if _, err := client.ImageRemove(ctx, tag, image.RemoveOptions{}); err != nil {
+			return fmt.Errorf("untag %q: %w", tag, err)
+		}
+	}
+
+	return nil
+}
+
+// Inspect returns the low-level information of the given image, like its size, labels and environment.
+func (c *imageClient) Inspect(ctx context.Context, ref string) (types.ImageInspect, error) {
+	client := c.provider.GetDockerClient()
+
+	info, _, err := client.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return types.ImageInspect{}, fmt.Errorf("inspect image %q: %w", ref, err)
+	}
+
+	return info, nil
+}
+
+// removeImage removes a single image, including its untagged parents.
+func (c *imageClient) removeImage(ctx context.Context, id string) error {
 	logger := simplog.FromContext(ctx)
 	client := c.provider.GetDockerClient()
 
+	responses, err := client.ImageRemove(ctx, id, image.RemoveOptions{
+		Force:         true,
+		PruneChildren: true,
+	})
+	if err != nil {
+		return fmt.Errorf("remove image %q: %w", id, err)
+	}
+
+	for _, response := range responses {
+		logger.Debugf("Removed image %v", response)
+	}
+
+	return nil
+}
+
+// Remove removes one or more docker images. Images are removed concurrently; a failed removal doesn't stop the others.
+// It returns the errors of all failed removals. It uses the docker API.
+func (c *imageClient) Remove(ctx context.Context, ids ...string) error {
+	var mu sync.Mutex
+	var failed []string
+
+	eg := &errgroup.Group{}
+	eg.SetLimit(max(c.removeConcurrency, 1))
+
 	for _, id := range ids {
-		responses, err := client.ImageRemove(ctx, id, image.RemoveOptions{
-			Force:         true,
-			PruneChildren: true,
+		id := id
+		eg.Go(func() error {
+			if err := c.removeImage(ctx, id); err != nil {
+				mu.Lock()
+				failed = append(failed, id)
+				mu.Unlock()
+			}
+
+			return nil
 		})
-		if err != nil {
-			return fmt.Errorf("remove image %q: %w", id, err)
-		}
+	}
 
-		for _, response := range responses {
-			logger.Debugf("Removed image %v", response)
+	_ = eg.Wait()
+
+	// An image can't be removed while a child image still exists, which may have been removed concurrently. Retry the
+	// failed removals one by one, now that their children should be gone.
+	var errs []error
+	for _, id := range failed {
+		if err := c.removeImage(ctx, id); err != nil {
+			errs = append(errs, err)
 		}
 	}
 
+	return errors.Join(errs...)
+}
+
+// List returns the local images that match all given label selectors, e.g. "maintainer=me" or just "maintainer" for
+// any value. Without selectors, all images are returned.
+func (c *imageClient) List(ctx context.Context, labels ...string) ([]image.Summary, error) {
+	client := c.provider.GetDockerClient()
+
+	args := filters.NewArgs()
+	for _, label := range labels {
+		args.Add("label", label)
+	}
+
+	images, err := client.ImageList(ctx, image.ListOptions{Filters: args})
+	if err != nil {
+		return nil, fmt.Errorf("list images: %w", err)
+	}
+
+	return images, nil
+}
+
+// Save writes the given image as a tar archive to w. It is the API equivalent of docker save.
+func (c *imageClient) Save(ctx context.Context, ref string, w io.Writer) error {
+	logger := simplog.FromContext(ctx)
+	client := c.provider.GetDockerClient()
+
+	logger.Debugf("Saving image %q", ref)
+
+	reader, err := client.ImageSave(ctx, []string{ref})
+	if err != nil {
+		return fmt.Errorf("save image %q: %w", ref, err)
+	}
+	defer reader.Close()
+
+	if _, err = io.Copy(w, reader); err != nil {
+		return fmt.Errorf("write image %q: %w", ref, err)
+	}
+
 	return nil
 }
+
+// Load loads one or more images from a tar archive, as created by Save or docker save. It returns the references of all
+// loaded images; tagged images are reported by name, untagged ones by their ID.
+func (c *imageClient) Load(ctx context.Context, r io.Reader) ([]string, error) {
+	logger := simplog.FromContext(ctx)
+	client := c.provider.GetDockerClient()
+
+	response, err := client.ImageLoad(ctx, r, true)
+	if err != nil {
+		return nil, fmt.Errorf("load image: %w", err)
+	}
+	defer response.Body.Close()
+
+	// Parse the load output for the loaded references and errors
+	var refs []string
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		line := &streamLine{}
+		if err := json.Unmarshal(scanner.Bytes(), line); err != nil {
+			continue
+		}
+
+		if line.Error != "" {
+			return nil, fmt.Errorf("load image: %w", errors.New(line.ErrorDetail.Message))
+		}
+
+		message := strings.TrimSpace(line.Stream)
+		for _, prefix := range loadedImagePrefixes {
+			if ref, ok := strings.CutPrefix(message, prefix); ok {
+				logger.Debugf("Loaded image %q", ref)
+				refs = append(refs, ref)
+			}
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read load response: %w", err)
+	}
+
+	return refs, nil
+}
+
+// RemoteDigest returns the digest of the given image reference as known to its registry, without pulling the image.
+// For multi-platform images, this is the digest of the image index.
+func (c *imageClient) RemoteDigest(ctx context.Context, ref string) (string, error) {
+	client := c.provider.GetDockerClient()
+
+	info, err := client.DistributionInspect(ctx, ref, c.provider.GetAuthToken())
+	if err != nil {
+		return "", fmt.Errorf("inspect distribution of %q: %w", ref, err)
+	}
+
+	return info.Descriptor.Digest.String(), nil
+}
@@ -0,0 +1,344 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/nikoksr/simplog"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// mediaTypeDockerManifest is the media type of Docker image manifests.
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	// mediaTypeDockerManifestList is the media type of Docker manifest lists, the Docker equivalent of OCI indexes.
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	// maxManifestSize is the maximum size of manifests read from registries.
+	maxManifestSize = 4 << 20
+)
+
+// manifestMediaTypes are the media types of the manifests and manifest lists that can be part of a manifest list.
+var manifestMediaTypes = []string{
+	ocispec.MediaTypeImageManifest, ocispec.MediaTypeImageIndex, mediaTypeDockerManifest, mediaTypeDockerManifestList,
+}
+
+type (
+	// PlatformImage is an image pushed for a single platform.
+	PlatformImage struct {
+		// Platform is the platform of the image in the format os/arch[/variant]; e.g. linux/arm64.
+		Platform string
+		// Digest is the manifest digest the registry reported for the pushed image.
+		Digest string
+	}
+
+	// ManifestClient is a client for the manifests of images in registries. It assembles multi-platform images from
+	// images pushed for a single platform each.
+	ManifestClient interface {
+		PushManifestList(ctx context.Context, repo string, images []PlatformImage, tags ...string) (string, error)
+	}
+
+	// Actual implementation of ManifestClient
+	manifestClient struct {
+		provider provider
+	}
+
+	// registryClient sends requests to the OCI distribution API of a single repository, authenticating as the
+	// registry demands it.
+	registryClient struct {
+		baseURL       string
+		auth          registry.AuthConfig
+		authorization string
+	}
+)
+
+func (c *Client) Manifests() ManifestClient {
+	return &manifestClient{provider: c}
+}
+
+// PushManifestList pushes a manifest list referencing the given images under the given tags of the repository and
+// returns its digest. The images have to be pushed to the repository already. The list is an OCI index if any image
+// uses OCI media types, and a Docker manifest list otherwise. Images that are indexes themselves, e.g. because they
+// carry attestations, contribute their manifests to the list.
+func (c *manifestClient) PushManifestList(ctx context.Context, repo string, images []PlatformImage, tags ...string) (string, error) {
+	logger := simplog.FromContext(ctx)
+
+	if len(images) == 0 {
+		return "", errors.New("no images provided")
+	}
+
+	client := newRegistryClient(repo, decodeAuthToken(c.provider.GetAuthToken()))
+
+	index := ocispec.Index{Versioned: specs.Versioned{SchemaVersion: 2}, MediaType: mediaTypeDockerManifestList}
+	for _, image := range images {
+		descriptor, manifests, err := client.getManifest(ctx, image.Digest)
+		if err != nil {
+			return "", fmt.Errorf("get manifest of %s image: %w", image.Platform, err)
+		}
+
+		if descriptor.MediaType != mediaTypeDockerManifest {
+			index.MediaType = ocispec.MediaTypeImageIndex
+		}
+
+		if manifests != nil {
+			index.Manifests = append(index.Manifests, manifests...)
+			continue
+		}
+
+		platform, err := parsePlatform(image.Platform)
+		if err != nil {
+			return "", err
+		}
+		descriptor.Platform = &platform
+
+		index.Manifests = append(index.Manifests, descriptor)
+	}
+
+	content, err := json.Marshal(index)
+	if err != nil {
+		return "", fmt.Errorf("encode manifest list: %w", err)
+	}
+
+	for _, tag := range tags {
+		logger.Debugf("Pushing manifest list %s:%s", repo, tag)
+		if err = client.putManifest(ctx, tag, index.MediaType, content); err != nil {
+			return "", &PushError{Tag: repo + ":" + tag, Err: err}
+		}
+	}
+
+	return digest.FromBytes(content).String(), nil
+}
+
+// ManifestCreateCommand returns the docker CLI command that creates a manifest list of the given images locally.
+func ManifestCreateCommand(ref string, images ...string) []string {
+	return append([]string{"docker", "manifest", "create", ref}, images...)
+}
+
+// ManifestPushCommand returns the docker CLI command that pushes a manifest list created locally.
+func ManifestPushCommand(ref string) []string {
+	return []string{"docker", "manifest", "push", ref}
+}
+
+// parsePlatform parses a platform in the format os/arch[/variant].
+func parsePlatform(platform string) (ocispec.Platform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return ocispec.Platform{}, fmt.Errorf("invalid platform %q; expected os/arch[/variant]", platform)
+	}
+
+	parsed := ocispec.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		parsed.Variant = parts[2]
+	}
+
+	return parsed, nil
+}
+
+// decodeAuthToken returns the credentials held by the given auth token, as set by the Login methods. Tokens that
+// aren't encoded auth configs are identity tokens.
+func decodeAuthToken(token string) registry.AuthConfig {
+	if token == "" {
+		return registry.AuthConfig{}
+	}
+
+	for _, encoding := range []*base64.Encoding{base64.URLEncoding, base64.StdEncoding} {
+		decoded, err := encoding.DecodeString(token)
+		if err != nil {
+			continue
+		}
+
+		var auth registry.AuthConfig
+		if err = json.Unmarshal(decoded, &auth); err == nil {
+			return auth
+		}
+	}
+
+	return registry.AuthConfig{IdentityToken: token}
+}
+
+// newRegistryClient returns a client for the distribution API of the given repository, authenticating with the given
+// credentials. Without credentials, it authenticates anonymously.
+func newRegistryClient(repo string, auth registry.AuthConfig) *registryClient {
+	host := RegistryHost(repo)
+
+	// Docker Hub serves the distribution API under a host of its own
+	var path string
+	if host == "docker.io" {
+		host, path = "registry-1.docker.io", dockerHubRepoPath(repo)
+	} else {
+		_, path, _ = strings.Cut(repo, "/")
+	}
+
+	return &registryClient{baseURL: fmt.Sprintf("%s://%s/v2/%s", registryScheme(host), host, path), auth: auth}
+}
+
+// registryScheme returns the URL scheme of the registry API on the given host. Registries on the local machine are
+// expected to serve plain HTTP.
+func registryScheme(host string) string {
+	if hostname, _, _ := strings.Cut(host, ":"); hostname == "localhost" || hostname == "127.0.0.1" {
+		return "http"
+	}
+
+	return "https"
+}
+
+// getManifest returns the descriptor of the manifest with the given digest and, if it's an index or manifest list,
+// the descriptors of the manifests it lists.
+func (r *registryClient) getManifest(ctx context.Context, dgst string) (ocispec.Descriptor, []ocispec.Descriptor, error) {
+	header := http.Header{"Accept": []string{strings.Join(manifestMediaTypes, ", ")}}
+	resp, err := r.do(ctx, http.MethodGet, "/manifests/"+dgst, header, nil)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ocispec.Descriptor{}, nil, registryError(resp)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return ocispec.Descriptor{}, nil, fmt.Errorf("read manifest: %w", err)
+	}
+
+	if actual := digest.FromBytes(content).String(); actual != dgst {
+		return ocispec.Descriptor{}, nil, fmt.Errorf("manifest digest %s doesn't match %s", actual, dgst)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return ocispec.Descriptor{}, nil, fmt.Errorf("parse manifest media type: %w", err)
+	}
+
+	descriptor := ocispec.Descriptor{MediaType: mediaType, Digest: digest.Digest(dgst), Size: int64(len(content))}
+	switch mediaType {
+	case ocispec.MediaTypeImageManifest, mediaTypeDockerManifest:
+		return descriptor, nil, nil
+	case ocispec.MediaTypeImageIndex, mediaTypeDockerManifestList:
+		var index ocispec.Index
+		if err = json.Unmarshal(content, &index); err != nil {
+			return ocispec.Descriptor{}, nil, fmt.Errorf("decode manifest list: %w", err)
+		}
+
+		return descriptor, index.Manifests, nil
+	default:
+		return ocispec.Descriptor{}, nil, fmt.Errorf("unsupported manifest media type %q", mediaType)
+	}
+}
+
+// putManifest pushes the given manifest under the given tag.
+func (r *registryClient) putManifest(ctx context.Context, tag, mediaType string, content []byte) error {
+	header := http.Header{"Content-Type": []string{mediaType}}
+	resp, err := r.do(ctx, http.MethodPut, "/manifests/"+url.PathEscape(tag), header, content)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		err = registryError(resp)
+		if isImmutableTagError(err) {
+			return fmt.Errorf("%w: %w", ErrTagImmutable, err)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// do sends a request to the given path of the repository. If the registry asks for authentication, the request is
+// authenticated as the registry's challenge demands and sent again; the authorization is kept for the following
+// requests.
+func (r *registryClient) do(ctx context.Context, method, path string, header http.Header, body []byte) (*http.Response, error) {
+	send := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, r.baseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+
+		for key, values := range header {
+			req.Header[key] = values
+		}
+
+		if r.authorization != "" {
+			req.Header.Set("Authorization", r.authorization)
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("send request: %w", err)
+		}
+
+		return resp, nil
+	}
+
+	resp, err := send()
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	_ = resp.Body.Close()
+
+	// Tokens are scoped; e.g. one for pulling doesn't allow pushing, so the registry challenges again for pushes
+	r.authorization, err = authorize(ctx, resp.Header.Get("WWW-Authenticate"), r.auth)
+	if err != nil {
+		return nil, fmt.Errorf("authenticate: %w", err)
+	}
+
+	return send()
+}
+
+// authorize returns the Authorization header answering the given WWW-Authenticate challenge with the given
+// credentials.
+func authorize(ctx context.Context, challenge string, auth registry.AuthConfig) (string, error) {
+	scheme, _, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Basic") {
+		token, err := fetchBearerToken(ctx, challenge, auth)
+		if err != nil {
+			return "", err
+		}
+
+		return "Bearer " + token, nil
+	}
+
+	if auth.Username == "" {
+		return "", fmt.Errorf("%w: registry requires credentials; log in first", ErrAuthFailed)
+	}
+
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+auth.Password)), nil
+}
+
+// registryError returns an error describing the given failed registry response, including the errors reported in
+// its body.
+func registryError(resp *http.Response) error {
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	content, _ := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err := json.Unmarshal(content, &body); err != nil || len(body.Errors) == 0 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	messages := make([]string, 0, len(body.Errors))
+	for _, e := range body.Errors {
+		messages = append(messages, fmt.Sprintf("%s: %s", e.Code, e.Message))
+	}
+
+	return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.Join(messages, "; "))
+}
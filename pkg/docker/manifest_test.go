@@ -0,0 +1,257 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	docker "github.com/docker/docker/client"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// staticProvider provides a fixed auth token and no daemon connection.
+type staticProvider struct {
+	authToken string
+}
+
+func (p *staticProvider) GetDockerClient() *docker.Client { return nil }
+
+func (p *staticProvider) GetAuthToken() string { return p.authToken }
+
+func (p *staticProvider) retryOnDisconnect(_ context.Context, fn func() error) error { return fn() }
+
+// fakeRegistry serves manifests of a single repository and records pushed ones. It requires a bearer token, which
+// its token endpoint only hands out for the given credentials.
+type fakeRegistry struct {
+	*httptest.Server
+
+	username, password string
+
+	mu        sync.Mutex
+	manifests map[string]fakeManifest // Keyed by digest
+	pushed    map[string]fakeManifest // Keyed by tag
+}
+
+type fakeManifest struct {
+	mediaType string
+	content   []byte
+}
+
+func newFakeRegistry(t *testing.T, username, password string) *fakeRegistry {
+	t.Helper()
+
+	r := &fakeRegistry{
+		username:  username,
+		password:  password,
+		manifests: make(map[string]fakeManifest),
+		pushed:    make(map[string]fakeManifest),
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+// add stores a manifest of the given media type and returns its digest.
+func (r *fakeRegistry) add(mediaType string, content []byte) string {
+	dgst := digest.FromBytes(content).String()
+	r.manifests[dgst] = fakeManifest{mediaType: mediaType, content: content}
+
+	return dgst
+}
+
+func (r *fakeRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	const token = "push-token"
+
+	if req.URL.Path == "/token" {
+		if user, pass, ok := req.BasicAuth(); !ok || user != r.username || pass != r.password {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+		if req.URL.Query().Get("scope") != "repository:me/repo:pull,push" {
+			http.Error(w, "bad scope", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"token":%q}`, token)
+		return
+	}
+
+	if req.Header.Get("Authorization") != "Bearer "+token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:me/repo:pull,push"`, r.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	reference, ok := strings.CutPrefix(req.URL.Path, "/v2/me/repo/manifests/")
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch req.Method {
+	case http.MethodGet:
+		manifest, ok := r.manifests[reference]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", manifest.mediaType)
+		_, _ = w.Write(manifest.content)
+	case http.MethodPut:
+		content, _ := io.ReadAll(req.Body)
+		r.pushed[reference] = fakeManifest{mediaType: req.Header.Get("Content-Type"), content: content}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func basicAuthToken(t *testing.T, username, password string) string {
+	t.Helper()
+
+	content, err := json.Marshal(map[string]string{"username": username, "password": password})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(content)
+}
+
+func TestPushManifestList(t *testing.T) {
+	disableRateLimit(t)
+
+	registry := newFakeRegistry(t, "john", "secret")
+	repo := strings.TrimPrefix(registry.URL, "http://") + "/me/repo"
+
+	amd64 := registry.add(mediaTypeDockerManifest, []byte(`{"schemaVersion":2,"config":{"digest":"sha256:a"}}`))
+	arm64 := registry.add(mediaTypeDockerManifest, []byte(`{"schemaVersion":2,"config":{"digest":"sha256:b"}}`))
+
+	client := &manifestClient{provider: &staticProvider{authToken: basicAuthToken(t, "john", "secret")}}
+	images := []PlatformImage{{Platform: "linux/amd64", Digest: amd64}, {Platform: "linux/arm64/v8", Digest: arm64}}
+
+	dgst, err := client.PushManifestList(context.Background(), repo, images, "16.3", "16")
+	if err != nil {
+		t.Fatalf("PushManifestList() error = %v", err)
+	}
+
+	for _, tag := range []string{"16.3", "16"} {
+		pushed, ok := registry.pushed[tag]
+		if !ok {
+			t.Fatalf("manifest list not pushed as %s", tag)
+		}
+
+		if pushed.mediaType != mediaTypeDockerManifestList {
+			t.Errorf("%s media type = %q, want %q", tag, pushed.mediaType, mediaTypeDockerManifestList)
+		}
+
+		if got := digest.FromBytes(pushed.content).String(); got != dgst {
+			t.Errorf("%s digest = %s, want %s", tag, got, dgst)
+		}
+	}
+
+	var list ocispec.Index
+	if err = json.Unmarshal(registry.pushed["16"].content, &list); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Manifests) != 2 {
+		t.Fatalf("manifest list has %d manifests, want 2", len(list.Manifests))
+	}
+
+	want := []struct {
+		digest   string
+		platform ocispec.Platform
+	}{
+		{digest: amd64, platform: ocispec.Platform{OS: "linux", Architecture: "amd64"}},
+		{digest: arm64, platform: ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+	}
+	for i, manifest := range list.Manifests {
+		if manifest.Digest.String() != want[i].digest || manifest.MediaType != mediaTypeDockerManifest {
+			t.Errorf("manifest %d = %s (%s), want %s (%s)", i, manifest.Digest, manifest.MediaType, want[i].digest, mediaTypeDockerManifest)
+		}
+
+		if manifest.Size != int64(len(registry.manifests[want[i].digest].content)) {
+			t.Errorf("manifest %d size = %d, want %d", i, manifest.Size, len(registry.manifests[want[i].digest].content))
+		}
+
+		if manifest.Platform == nil || manifest.Platform.OS != want[i].platform.OS ||
+			manifest.Platform.Architecture != want[i].platform.Architecture || manifest.Platform.Variant != want[i].platform.Variant {
+			t.Errorf("manifest %d platform = %+v, want %+v", i, manifest.Platform, want[i].platform)
+		}
+	}
+}
+
+func TestPushManifestListMergesIndexes(t *testing.T) {
+	disableRateLimit(t)
+
+	registry := newFakeRegistry(t, "john", "secret")
+	repo := strings.TrimPrefix(registry.URL, "http://") + "/me/repo"
+
+	// An image with a provenance attestation is pushed as index of the image and the attestation manifest
+	amd64 := registry.add(ocispec.MediaTypeImageManifest, []byte(`{"schemaVersion":2,"config":{"digest":"sha256:a"}}`))
+	attested := registry.add(ocispec.MediaTypeImageIndex, []byte(fmt.Sprintf(`{"schemaVersion":2,"manifests":[`+
+		`{"mediaType":%q,"digest":"sha256:c","size":10,"platform":{"os":"linux","architecture":"arm64"}},`+
+		`{"mediaType":%q,"digest":"sha256:d","size":20,"platform":{"os":"unknown","architecture":"unknown"},`+
+		`"annotations":{"vnd.docker.reference.digest":"sha256:c","vnd.docker.reference.type":"attestation-manifest"}}]}`,
+		ocispec.MediaTypeImageManifest, ocispec.MediaTypeImageManifest)))
+
+	client := &manifestClient{provider: &staticProvider{authToken: basicAuthToken(t, "john", "secret")}}
+	images := []PlatformImage{{Platform: "linux/amd64", Digest: amd64}, {Platform: "linux/arm64", Digest: attested}}
+
+	if _, err := client.PushManifestList(context.Background(), repo, images, "16"); err != nil {
+		t.Fatalf("PushManifestList() error = %v", err)
+	}
+
+	pushed := registry.pushed["16"]
+	if pushed.mediaType != ocispec.MediaTypeImageIndex {
+		t.Errorf("media type = %q, want %q", pushed.mediaType, ocispec.MediaTypeImageIndex)
+	}
+
+	var index ocispec.Index
+	if err := json.Unmarshal(pushed.content, &index); err != nil {
+		t.Fatal(err)
+	}
+
+	var digests []string
+	for _, manifest := range index.Manifests {
+		digests = append(digests, manifest.Digest.String())
+	}
+
+	if want := []string{amd64, "sha256:c", "sha256:d"}; strings.Join(digests, ",") != strings.Join(want, ",") {
+		t.Errorf("index manifests = %v, want %v", digests, want)
+	}
+
+	if index.Manifests[2].Annotations["vnd.docker.reference.digest"] != "sha256:c" {
+		t.Errorf("attestation manifest lost its annotations: %+v", index.Manifests[2])
+	}
+}
+
+func TestPushManifestListRequiresCredentials(t *testing.T) {
+	disableRateLimit(t)
+
+	registry := newFakeRegistry(t, "john", "secret")
+	repo := strings.TrimPrefix(registry.URL, "http://") + "/me/repo"
+	amd64 := registry.add(mediaTypeDockerManifest, []byte(`{"schemaVersion":2}`))
+
+	client := &manifestClient{provider: &staticProvider{authToken: basicAuthToken(t, "john", "wrong")}}
+	_, err := client.PushManifestList(context.Background(), repo, []PlatformImage{{Platform: "linux/amd64", Digest: amd64}}, "16")
+	if err == nil || !strings.Contains(err.Error(), ErrAuthFailed.Error()) {
+		t.Fatalf("PushManifestList() error = %v, want %v", err, ErrAuthFailed)
+	}
+
+	if len(registry.pushed) != 0 {
+		t.Errorf("pushed %d manifests without valid credentials", len(registry.pushed))
+	}
+}
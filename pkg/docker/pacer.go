@@ -0,0 +1,72 @@
+package docker
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPushAttempts is the number of attempts made to push a single reference if the registry rate limits us.
+	maxPushAttempts = 5
+	// minPushBackoff and maxPushBackoff bound the delay between pushes after hitting a rate limit.
+	minPushBackoff = 5 * time.Second
+	maxPushBackoff = 5 * time.Minute
+)
+
+// pushPacer paces pushes adaptively. The daemon doesn't hand the registry's rate limit headers through, so the pacer
+// reacts to rate limit errors in the push stream instead: every hit doubles the delay between pushes, every successful
+// push halves it again. The pacer is shared by all image clients of a Client, so the delay carries over between
+// versions.
+type pushPacer struct {
+	mu    sync.Mutex
+	delay time.Duration
+}
+
+// wait blocks for the current delay or until the context is done.
+func (p *pushPacer) wait(ctx context.Context) error {
+	p.mu.Lock()
+	delay := p.delay
+	p.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// limited records a rate limit hit and returns the new delay.
+func (p *pushPacer) limited() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.delay = min(max(2*p.delay, minPushBackoff), maxPushBackoff)
+
+	return p.delay
+}
+
+// succeeded records a successful push.
+func (p *pushPacer) succeeded() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.delay /= 2
+	if p.delay < time.Second {
+		p.delay = 0
+	}
+}
+
+// isRateLimitError reports whether the given push error was caused by the registry's rate limit.
+func isRateLimitError(err error) bool {
+	message := strings.ToLower(err.Error())
+
+	return strings.Contains(message, "toomanyrequests") || strings.Contains(message, "too many requests") ||
+		strings.Contains(message, "429")
+}
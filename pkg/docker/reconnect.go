@@ -0,0 +1,96 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	docker "github.com/docker/docker/client"
+	"github.com/nikoksr/simplog"
+)
+
+// reconnectDelay is the delay before the first reconnect attempt; it grows linearly with every further attempt.
+const reconnectDelay = 5 * time.Second
+
+// WithReconnect makes the client reconnect to the docker daemon and retry a build or push up to the given number of
+// times if the connection to the daemon drops midway. Build, auth and registry errors are never retried.
+func WithReconnect(attempts int) Option {
+	return func(c *Client) {
+		c.reconnectAttempts = attempts
+	}
+}
+
+// isConnectionError reports whether the given error was caused by the connection to the docker daemon, as opposed to
+// an error reported by the daemon itself; e.g. a failed build step.
+func isConnectionError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if docker.IsErrConnectionFailed(err) {
+		return true
+	}
+
+	for _, target := range []error{io.ErrUnexpectedEOF, net.ErrClosed, syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.EPIPE} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	var opErr *net.OpError
+
+	return errors.As(err, &opErr)
+}
+
+// retryOnDisconnect runs fn and, as long as it fails because of the connection to the daemon, reconnects and runs it
+// again, up to the configured number of reconnect attempts.
+func (c *Client) retryOnDisconnect(ctx context.Context, fn func() error) error {
+	logger := simplog.FromContext(ctx)
+
+	err := fn()
+	for attempt := 1; attempt <= c.reconnectAttempts && isConnectionError(err); attempt++ {
+		delay := time.Duration(attempt) * reconnectDelay
+		logger.Warnf("Lost connection to docker daemon: %v; reconnecting in %s (attempt %d/%d)", err, delay, attempt, c.reconnectAttempts)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		if reconnectErr := c.reconnect(ctx); reconnectErr != nil {
+			logger.Warnf("Failed to reconnect to docker daemon: %v", reconnectErr)
+			continue
+		}
+
+		err = fn()
+	}
+
+	return err
+}
+
+// reconnect replaces the API client with a fresh one, once the daemon answers again.
+func (c *Client) reconnect(ctx context.Context) error {
+	client, err := newDockerClient(c.host)
+	if err != nil {
+		return fmt.Errorf("create docker client: %w", err)
+	}
+
+	if _, err = client.Ping(ctx); err != nil {
+		_ = client.Close()
+		return fmt.Errorf("ping docker daemon at %s: %w: %w", client.DaemonHost(), ErrDaemonUnreachable, err)
+	}
+
+	c.mu.Lock()
+	previous := c.dockerClient
+	c.dockerClient = client
+	c.mu.Unlock()
+
+	_ = previous.Close()
+
+	return nil
+}
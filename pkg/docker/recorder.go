@@ -0,0 +1,138 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+type (
+	// recordedResponse is a registry API response as stored in a fixture file.
+	recordedResponse struct {
+		StatusCode int         `json:"status_code"`
+		Header     http.Header `json:"header,omitempty"`
+		Body       string      `json:"body"`
+	}
+
+	// recordTransport hands requests to the underlying transport and saves every response to a fixture file, keyed by
+	// request URL.
+	recordTransport struct {
+		base      http.RoundTripper
+		path      string
+		mu        sync.Mutex
+		responses map[string]recordedResponse
+	}
+
+	// replayTransport answers requests from the responses of a fixture file instead of sending them.
+	replayTransport struct {
+		path      string
+		responses map[string]recordedResponse
+	}
+)
+
+// recordedHeaders are the response headers kept in fixture files; the ones the registry API clients read.
+var recordedHeaders = []string{"Content-Type", "Link", "WWW-Authenticate"}
+
+// RecordRegistryResponses saves the responses of all registry API requests, i.e. tag discovery, to the given fixture
+// file, so they can be replayed with ReplayRegistryResponses later. The file is overwritten. It is not safe to call it
+// concurrently with requests.
+func RecordRegistryResponses(path string) {
+	httpClient.Transport = &userAgentTransport{base: &rateLimitTransport{
+		base:    &recordTransport{base: httpTransport, path: path, responses: make(map[string]recordedResponse)},
+		limiter: rateLimiter,
+	}}
+}
+
+// ReplayRegistryResponses answers all registry API requests from the given fixture file, written by
+// RecordRegistryResponses, instead of sending them. Requests without a recorded response fail. It is not safe to call
+// it concurrently with requests.
+func ReplayRegistryResponses(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read registry fixtures: %w", err)
+	}
+
+	var responses map[string]recordedResponse
+	if err = json.Unmarshal(data, &responses); err != nil {
+		return fmt.Errorf("decode registry fixtures: %w", err)
+	}
+
+	httpClient.Transport = &replayTransport{path: path, responses: responses}
+
+	return nil
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	recorded := recordedResponse{StatusCode: resp.StatusCode, Header: make(http.Header), Body: string(body)}
+	for _, key := range recordedHeaders {
+		if values := resp.Header.Values(key); len(values) > 0 {
+			recorded.Header[key] = values
+		}
+	}
+
+	if err = t.save(req.URL.String(), recorded); err != nil {
+		return nil, err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	return resp, nil
+}
+
+// save adds the response to the fixture file.
+func (t *recordTransport) save(url string, resp recordedResponse) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.responses[url] = resp
+
+	data, err := json.MarshalIndent(t.responses, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode registry fixtures: %w", err)
+	}
+
+	if err = os.WriteFile(t.path, data, 0o600); err != nil {
+		return fmt.Errorf("write registry fixtures: %w", err)
+	}
+
+	return nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorded, ok := t.responses[req.URL.String()]
+	if !ok {
+		return nil, fmt.Errorf("no response for %s recorded in %s", req.URL, t.path)
+	}
+
+	header := recorded.Header
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(recorded.Body))),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,304 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/registry"
+)
+
+var (
+	_ TagLister = (*dockerHubTagLister)(nil)
+	_ TagLister = (*distributionTagLister)(nil)
+)
+
+type (
+	// TagLister lists the tags of a repository. Each registry API gets its own implementation, with its own response
+	// shape and pagination.
+	TagLister interface {
+		ListTags(ctx context.Context, repo string) ([]Tag, error)
+		// WalkTags lists the tags page by page, starting at the given cursor, and calls fn after every page. The cursor
+		// passed to fn resumes the listing after that page; it is empty after the last page.
+		WalkTags(ctx context.Context, repo, cursor string, fn PageFunc) error
+	}
+
+	// PageFunc is called by TagLister.WalkTags with the tags of a page and the cursor of the next page.
+	PageFunc func(tags []Tag, next string) error
+
+	// Tag is a tag of a repository.
+	Tag struct {
+		Name string
+		// Pushed is the time the tag was last pushed; zero if the registry API doesn't report it.
+		Pushed time.Time
+	}
+
+	// dockerHubTagLister lists tags through the Docker Hub API, which paginates via a "next" URL in the response body.
+	dockerHubTagLister struct{}
+
+	// distributionTagLister lists tags through the OCI distribution API (/v2/<name>/tags/list), which is implemented by
+	// most registries, like ghcr.io, Harbor, Nexus or the reference registry. It paginates via the Link header and
+	// authenticates anonymously with a bearer token if the registry asks for one.
+	distributionTagLister struct {
+		host string
+	}
+
+	distributionTagsResponse struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}
+
+	tokenResponse struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+)
+
+var (
+	patternAuthParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+	patternNextLink  = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+)
+
+// NewTagLister returns the TagLister for the registry of the given repository.
+func NewTagLister(repo string) TagLister {
+	host := RegistryHost(repo)
+	if host == "docker.io" {
+		return &dockerHubTagLister{}
+	}
+
+	return &distributionTagLister{host: host}
+}
+
+// ListTags returns all tags of the given repository, using the API of the registry it lives on.
+func ListTags(ctx context.Context, repo string) ([]Tag, error) {
+	return NewTagLister(repo).ListTags(ctx, repo)
+}
+
+// WalkTags lists the tags of the given repository page by page, using the API of the registry it lives on. It starts
+// at the given cursor, as passed to fn by an earlier, interrupted walk, or at the first page if the cursor is empty.
+func WalkTags(ctx context.Context, repo, cursor string, fn PageFunc) error {
+	return NewTagLister(repo).WalkTags(ctx, repo, cursor, fn)
+}
+
+func (l *dockerHubTagLister) ListTags(ctx context.Context, repo string) ([]Tag, error) {
+	return getAllTags(ctx, repo)
+}
+
+func (l *dockerHubTagLister) WalkTags(ctx context.Context, repo, cursor string, fn PageFunc) error {
+	return walkTags(ctx, repo, cursor, fn)
+}
+
+// repoPath strips the registry host from the given repository.
+func (l *distributionTagLister) repoPath(repo string) string {
+	if host, path, found := strings.Cut(repo, "/"); found && normalizeRegistryHost(host) == l.host {
+		return path
+	}
+
+	return repo
+}
+
+func (l *distributionTagLister) ListTags(ctx context.Context, repo string) ([]Tag, error) {
+	var tags []Tag
+	err := l.WalkTags(ctx, repo, "", func(page []Tag, _ string) error {
+		tags = append(tags, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func (l *distributionTagLister) WalkTags(ctx context.Context, repo, cursor string, fn PageFunc) error {
+	next := cursor
+	if next == "" {
+		next = fmt.Sprintf("%s://%s/v2/%s/tags/list?n=%d", registryScheme(l.host), l.host, l.repoPath(repo), registryAPIPageLimit)
+	}
+
+	var token string
+	guard := newPageGuard(repo, next)
+	for next != "" {
+		var err error
+		var page distributionTagsResponse
+		page, next, err = l.getTags(ctx, next, &token)
+		if err != nil {
+			return fmt.Errorf("get tags: %w", err)
+		}
+
+		next = guard.next(ctx, next)
+
+		tags := make([]Tag, 0, len(page.Tags))
+		for _, name := range page.Tags {
+			tags = append(tags, Tag{Name: name})
+		}
+
+		if err = fn(tags, next); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getTags fetches a single page of tags. If the registry requires a bearer token, an anonymous one is requested and
+// stored in token for the following pages.
+func (l *distributionTagLister) getTags(ctx context.Context, pageURL string, token *string) (distributionTagsResponse, string, error) {
+	resp, err := l.do(ctx, pageURL, *token)
+	if err != nil {
+		return distributionTagsResponse{}, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized && *token == "" {
+		_ = resp.Body.Close()
+
+		*token, err = fetchBearerToken(ctx, resp.Header.Get("WWW-Authenticate"), registry.AuthConfig{})
+		if err != nil {
+			return distributionTagsResponse{}, "", fmt.Errorf("authenticate: %w", err)
+		}
+
+		resp, err = l.do(ctx, pageURL, *token)
+		if err != nil {
+			return distributionTagsResponse{}, "", err
+		}
+		defer resp.Body.Close()
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return distributionTagsResponse{}, "", ErrRepoNotFound
+	case resp.StatusCode != http.StatusOK:
+		return distributionTagsResponse{}, "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var page distributionTagsResponse
+	if err = json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return distributionTagsResponse{}, "", fmt.Errorf("decode response: %w", err)
+	}
+
+	next, err := nextPageURL(pageURL, resp.Header.Get("Link"))
+	if err != nil {
+		return distributionTagsResponse{}, "", err
+	}
+
+	return page, next, nil
+}
+
+func (l *distributionTagLister) do(ctx context.Context, pageURL, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("send request: %w", err)
+	}
+
+	return resp, nil
+}
+
+// nextPageURL returns the URL of the next page from the given Link header, resolved against the current page URL. It
+// returns an empty string if there is no next page.
+func nextPageURL(pageURL, link string) (string, error) {
+	match := patternNextLink.FindStringSubmatch(link)
+	if match == nil {
+		return "", nil
+	}
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return "", fmt.Errorf("parse page url: %w", err)
+	}
+
+	next, err := base.Parse(match[1])
+	if err != nil {
+		return "", fmt.Errorf("parse next page url: %w", err)
+	}
+
+	return next.String(), nil
+}
+
+// fetchBearerToken requests a token from the auth server named in the given WWW-Authenticate challenge; e.g. Bearer
+// realm="https://ghcr.io/token",service="ghcr.io",scope="repository:me/repo:pull". Without credentials, the token is
+// anonymous. Identity tokens are exchanged in an OAuth2 refresh token grant, other credentials are sent as basic auth.
+func fetchBearerToken(ctx context.Context, challenge string, auth registry.AuthConfig) (string, error) {
+	scheme, rawParams, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("%w: unsupported auth challenge %q", ErrAuthFailed, challenge)
+	}
+
+	params := make(map[string]string)
+	for _, match := range patternAuthParam.FindAllStringSubmatch(rawParams, -1) {
+		params[match[1]] = match[2]
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("%w: invalid auth realm %q", ErrAuthFailed, params["realm"])
+	}
+
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if value := params[key]; value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	var req *http.Request
+	if auth.IdentityToken != "" {
+		query.Set("grant_type", "refresh_token")
+		query.Set("refresh_token", auth.IdentityToken)
+		query.Set("client_id", userAgent)
+
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm.String(), strings.NewReader(query.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		realm.RawQuery = query.Encode()
+
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+		if err == nil && auth.Username != "" {
+			req.SetBasicAuth(auth.Username, auth.Password)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("create token request: %w", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("send token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: token request returned %s", ErrAuthFailed, resp.Status)
+	}
+
+	var token tokenResponse
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("decode token response: %w", err)
+	}
+
+	if token.Token != "" {
+		return token.Token, nil
+	}
+
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+
+	return "", fmt.Errorf("%w: empty token", ErrAuthFailed)
+}
@@ -0,0 +1,170 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// disableRateLimit lifts the registry API rate limit for the duration of the test.
+func disableRateLimit(t *testing.T) {
+	t.Helper()
+
+	SetRateLimit(0)
+	t.Cleanup(func() { SetRateLimit(DefaultRegistryRPS) })
+}
+
+func tagNames(tags []Tag) []string {
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+
+	return names
+}
+
+func TestDockerHubTagListerPagination(t *testing.T) {
+	disableRateLimit(t)
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/repositories/library/postgres/tags" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("page") {
+		case "1":
+			fmt.Fprintf(w, `{"next":"%s/v2/repositories/library/postgres/tags?page=2&page_size=2","results":[`+
+				`{"name":"17.0","tag_last_pushed":"2024-09-26T10:00:00Z"},{"name":"16.4","tag_last_pushed":"2024-08-08T10:00:00Z"}]}`, srv.URL)
+		case "2":
+			fmt.Fprint(w, `{"next":null,"results":[{"name":"16.3","tag_last_pushed":"2024-05-09T10:00:00Z"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	defaultURL := patternRegistryTagsURL
+	patternRegistryTagsURL = srv.URL + "/v2/repositories/%s/tags?page=1&page_size=%d"
+	t.Cleanup(func() { patternRegistryTagsURL = defaultURL })
+
+	lister := NewTagLister("postgres")
+	if _, ok := lister.(*dockerHubTagLister); !ok {
+		t.Fatalf("NewTagLister() = %T, want %T", lister, &dockerHubTagLister{})
+	}
+
+	tags, err := lister.ListTags(context.Background(), "postgres")
+	if err != nil {
+		t.Fatalf("ListTags() error = %v", err)
+	}
+
+	if got, want := tagNames(tags), []string{"17.0", "16.4", "16.3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListTags() = %v, want %v", got, want)
+	}
+
+	if want := time.Date(2024, 5, 9, 10, 0, 0, 0, time.UTC); !tags[2].Pushed.Equal(want) {
+		t.Errorf("ListTags()[2].Pushed = %v, want %v", tags[2].Pushed, want)
+	}
+}
+
+func TestDistributionTagListerPagination(t *testing.T) {
+	disableRateLimit(t)
+
+	const token = "anonymous-token"
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if r.URL.Query().Get("scope") != "repository:me/repo:pull" {
+				http.Error(w, "bad scope", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, `{"token":%q}`, token)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:me/repo:pull"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path != "/v2/me/repo/tags/list" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("last") {
+		case "":
+			w.Header().Set("Link", `</v2/me/repo/tags/list?n=2&last=1.1>; rel="next"`)
+			fmt.Fprint(w, `{"name":"me/repo","tags":["1.0","1.1"]}`)
+		case "1.1":
+			w.Header().Set("Link", `</v2/me/repo/tags/list?n=2&last=2.0>; rel="next"`)
+			fmt.Fprint(w, `{"name":"me/repo","tags":["1.2","2.0"]}`)
+		case "2.0":
+			fmt.Fprint(w, `{"name":"me/repo","tags":["2.1"]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	repo := host + "/me/repo"
+
+	lister := NewTagLister(repo)
+	if _, ok := lister.(*distributionTagLister); !ok {
+		t.Fatalf("NewTagLister() = %T, want %T", lister, &distributionTagLister{})
+	}
+
+	var pages [][]string
+	var cursors []string
+	err := lister.WalkTags(context.Background(), repo, "", func(tags []Tag, next string) error {
+		pages = append(pages, tagNames(tags))
+		cursors = append(cursors, next)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkTags() error = %v", err)
+	}
+
+	if want := [][]string{{"1.0", "1.1"}, {"1.2", "2.0"}, {"2.1"}}; !reflect.DeepEqual(pages, want) {
+		t.Errorf("WalkTags() pages = %v, want %v", pages, want)
+	}
+
+	wantCursors := []string{srv.URL + "/v2/me/repo/tags/list?n=2&last=1.1", srv.URL + "/v2/me/repo/tags/list?n=2&last=2.0", ""}
+	if !reflect.DeepEqual(cursors, wantCursors) {
+		t.Errorf("WalkTags() cursors = %v, want %v", cursors, wantCursors)
+	}
+
+	// Resuming at a cursor continues with the page after it
+	var resumed []string
+	err = lister.WalkTags(context.Background(), repo, cursors[0], func(tags []Tag, _ string) error {
+		resumed = append(resumed, tagNames(tags)...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkTags() error = %v", err)
+	}
+
+	if want := []string{"1.2", "2.0", "2.1"}; !reflect.DeepEqual(resumed, want) {
+		t.Errorf("WalkTags() resumed = %v, want %v", resumed, want)
+	}
+
+	tags, err := lister.ListTags(context.Background(), repo)
+	if err != nil {
+		t.Fatalf("ListTags() error = %v", err)
+	}
+
+	if got, want := tagNames(tags), []string{"1.0", "1.1", "1.2", "2.0", "2.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListTags() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,54 @@
+package docker
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BuildStep is a single step of a build, i.e. a Dockerfile instruction, and the time it took.
+type BuildStep struct {
+	Number      int
+	Instruction string
+	Duration    time.Duration
+}
+
+// patternBuildStep matches the step markers of the classic builder's output; e.g. "Step 2/5 : RUN apt-get update".
+var patternBuildStep = regexp.MustCompile(`^Step (\d+)/\d+ : (.*)$`)
+
+// stepTimer times the steps of a build from the step markers in its output. The builder doesn't report when a step
+// ends, so a step is considered done when the next one starts or the output ends.
+type stepTimer struct {
+	current *BuildStep
+	started time.Time
+}
+
+// observe processes a message of the build output and returns the step it finished, if any.
+func (t *stepTimer) observe(message string) *BuildStep {
+	match := patternBuildStep.FindStringSubmatch(strings.TrimSpace(message))
+	if match == nil {
+		return nil
+	}
+
+	finished := t.finish()
+
+	number, _ := strconv.Atoi(match[1])
+	t.current = &BuildStep{Number: number, Instruction: match[2]}
+	t.started = time.Now()
+
+	return finished
+}
+
+// finish ends the current step and returns it; nil if there is none.
+func (t *stepTimer) finish() *BuildStep {
+	if t.current == nil {
+		return nil
+	}
+
+	step := t.current
+	step.Duration = time.Since(t.started)
+	t.current = nil
+
+	return step
+}